@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// how addresses are distributed over the IA_NAs of a client
+const (
+	// assignAll hands out every matching address, spread round robin over the IA_NAs
+	assignAll = "all"
+	// assignSingle hands out one address per IA_NA
+	assignSingle = "single"
+)
+
+var assignModes = []string{assignAll, assignSingle}
+
+func validAssignMode(mode string) error {
+	for _, m := range assignModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid address assignment '%s'. Valid modes are %v", mode, assignModes)
+}
+
+// assignAddresses distributes addrs over iaids. Both get sorted first, so the same client
+// always ends up with the same addresses in the same IA, no matter in which order routes or IAs show up
+func assignAddresses(iaids [][4]byte, addrs []net.IP, mode string) map[[4]byte][]net.IP {
+	ids := make([][4]byte, len(iaids))
+	copy(ids, iaids)
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	ips := make([]net.IP, len(addrs))
+	copy(ips, addrs)
+	sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0 })
+
+	a := make(map[[4]byte][]net.IP)
+	if len(ids) == 0 {
+		return a
+	}
+	for i, ip := range ips {
+		if mode == assignSingle && i >= len(ids) {
+			break
+		}
+		id := ids[i%len(ids)]
+		a[id] = append(a[id], ip)
+	}
+	return a
+}
+
+// iaNAOptions answers every IA_NA of msg and returns the addresses handed out, first one belonging to the lowest IAID.
+// a client without any IA_NA is answered as if it sent one with IAID 0
+func iaNAOptions(msg *dhcpv6.Message, addrs []net.IP, mode string, lt lifetimesFunc) ([]dhcpv6.Option, []net.IP) {
+	ias := msg.Options.IANA()
+	if len(ias) == 0 {
+		ias = []*dhcpv6.OptIANA{{}}
+	}
+	iaids := make([][4]byte, len(ias))
+	for i, ia := range ias {
+		iaids[i] = ia.IaId
+	}
+	assigned := assignAddresses(iaids, addrs, mode)
+	lowest := lowestIAID(iaids)
+
+	var opts []dhcpv6.Option
+	var handedOut []net.IP
+	for _, ia := range ias {
+		r := &dhcpv6.OptIANA{IaId: ia.IaId}
+
+		var clientAddrs []net.IP
+		for _, a := range ia.Options.Addresses() {
+			clientAddrs = append(clientAddrs, a.IPv6Addr)
+		}
+		// we don't keep state, a binding is known as long as there is a host route for every address the client has
+		var ips []net.IP
+		switch b := iaBinding(msg.Type(), len(clientAddrs), !containsAllIPs(addrs, clientAddrs)); b {
+		case bindingUnknown:
+			r.Options.Add(b.status())
+		case bindingGone:
+			// returning the addresses with lifetimes of 0 makes the client drop them right away
+			for _, ip := range clientAddrs {
+				r.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: ip})
+			}
+			r.Options.Add(b.status())
+		case bindingKnown:
+			// keep extending what the client has
+			ips = clientAddrs
+		default:
+			if len(assigned[ia.IaId]) == 0 {
+				r.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail, StatusMessage: "no addresses available"})
+			}
+			ips = assigned[ia.IaId]
+		}
+
+		var alt []lifetimes
+		for _, ip := range ips {
+			l := lt(hostRoute(ip))
+			alt = append(alt, l)
+			r.Options.Add(&dhcpv6.OptIAAddress{
+				IPv6Addr:          ip,
+				PreferredLifetime: l.preferred,
+				ValidLifetime:     l.valid,
+			})
+		}
+		r.T1, r.T2 = iaTimers(alt)
+		if ia.IaId == lowest {
+			handedOut = append(ips, handedOut...)
+		} else {
+			handedOut = append(handedOut, ips...)
+		}
+		opts = append(opts, r)
+	}
+	return opts, handedOut
+}
+
+func lowestIAID(iaids [][4]byte) [4]byte {
+	var l [4]byte
+	for i, id := range iaids {
+		if i == 0 || bytes.Compare(id[:], l[:]) < 0 {
+			l = id
+		}
+	}
+	return l
+}
+
+// bindingState is what a client sending its leases in an IA tells us about its binding
+type bindingState int
+
+const (
+	// bindingNew means there is nothing to check, the IA gets fresh leases
+	bindingNew bindingState = iota
+	// bindingKnown means every lease of the IA is still routed to the client
+	bindingKnown
+	// bindingUnknown is a Renew of leases we don't route (anymore) or of an IA without any
+	bindingUnknown
+	// bindingGone is a Rebind of leases we don't route anymore
+	bindingGone
+)
+
+// iaBinding decides how a Renew or Rebind of an IA holding leases is answered, stale tells if any of them isn't routed to the client.
+// it is shared by IA_NA, IA_TA and IA_PD so all of them treat a lost binding the same
+func iaBinding(msgType dhcpv6.MessageType, leases int, stale bool) bindingState {
+	switch {
+	case msgType == dhcpv6.MessageTypeRenew && (stale || leases == 0):
+		return bindingUnknown
+	case msgType == dhcpv6.MessageTypeRebind && stale:
+		return bindingGone
+	case (msgType == dhcpv6.MessageTypeRenew || msgType == dhcpv6.MessageTypeRebind) && leases > 0:
+		return bindingKnown
+	}
+	return bindingNew
+}
+
+// status is the status code an IA with binding b is answered with, nil if it gets leases
+func (b bindingState) status() *dhcpv6.OptStatusCode {
+	switch b {
+	case bindingUnknown:
+		return &dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding, StatusMessage: "no binding for this IA"}
+	case bindingGone:
+		return &dhcpv6.OptStatusCode{StatusCode: iana.StatusNotOnLink, StatusMessage: "lease not on link"}
+	}
+	return nil
+}
+
+// statusCodes collects the top level status and the status of every IA in resp
+func statusCodes(resp *dhcpv6.Message) []*dhcpv6.OptStatusCode {
+	var st []*dhcpv6.OptStatusCode
+	if s := resp.Options.Status(); s != nil {
+		st = append(st, s)
+	}
+	for _, ia := range resp.Options.IANA() {
+		if s := ia.Options.Status(); s != nil {
+			st = append(st, s)
+		}
+	}
+	for _, ia := range resp.Options.IATA() {
+		if s := ia.Options.Status(); s != nil {
+			st = append(st, s)
+		}
+	}
+	for _, ia := range resp.Options.IAPD() {
+		if s := ia.Options.Status(); s != nil {
+			st = append(st, s)
+		}
+	}
+	return st
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func testLifetimes(n *net.IPNet) lifetimes {
+	return lifetimes{preferred: time.Hour, valid: 2 * time.Hour, t1: 30 * time.Minute, t2: 48 * time.Minute}
+}
+
+func TestIABinding(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    dhcpv6.MessageType
+		leases int
+		stale  bool
+		want   bindingState
+	}{
+		{"solicit", dhcpv6.MessageTypeSolicit, 0, false, bindingNew},
+		{"request with stale hint", dhcpv6.MessageTypeRequest, 1, true, bindingNew},
+		{"renew", dhcpv6.MessageTypeRenew, 1, false, bindingKnown},
+		{"renew stale", dhcpv6.MessageTypeRenew, 2, true, bindingUnknown},
+		{"renew without leases", dhcpv6.MessageTypeRenew, 0, false, bindingUnknown},
+		{"rebind", dhcpv6.MessageTypeRebind, 1, false, bindingKnown},
+		{"rebind stale", dhcpv6.MessageTypeRebind, 1, true, bindingGone},
+		{"rebind without leases", dhcpv6.MessageTypeRebind, 0, false, bindingNew},
+	}
+	for _, tt := range tests {
+		if got := iaBinding(tt.typ, tt.leases, tt.stale); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// every IA type has to answer a lost binding the same way
+func TestStaleBindings(t *testing.T) {
+	routed := net.ParseIP("2001:db8::1")
+	stale := net.ParseIP("2001:db8::99")
+	_, routedPrefix, _ := net.ParseCIDR("2001:db8:1::/56")
+	_, stalePrefix, _ := net.ParseCIDR("2001:db8:2::/56")
+
+	tests := []struct {
+		name string
+		typ  dhcpv6.MessageType
+		addr net.IP
+		pfx  *net.IPNet
+		want iana.StatusCode
+	}{
+		{"renew stale", dhcpv6.MessageTypeRenew, stale, stalePrefix, iana.StatusNoBinding},
+		{"renew without leases", dhcpv6.MessageTypeRenew, nil, nil, iana.StatusNoBinding},
+		{"rebind stale", dhcpv6.MessageTypeRebind, stale, stalePrefix, iana.StatusNotOnLink},
+		{"renew known", dhcpv6.MessageTypeRenew, routed, routedPrefix, iana.StatusSuccess},
+	}
+	for _, tt := range tests {
+		na := &dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}}
+		ta := &dhcpv6.OptIATA{IaId: [4]byte{0, 0, 0, 2}}
+		pd := &dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 3}}
+		if tt.addr != nil {
+			na.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: tt.addr})
+			ta.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: tt.addr})
+			pd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: tt.pfx})
+		}
+		msg, _ := dhcpv6.NewMessage()
+		msg.MessageType = tt.typ
+		msg.AddOption(na)
+		msg.AddOption(ta)
+		msg.AddOption(pd)
+
+		naOpts, _ := iaNAOptions(msg, []net.IP{routed}, assignAll, testLifetimes)
+		resp, _ := dhcpv6.NewMessage()
+		resp.Options.Options = append(naOpts, iaTAOptions(msg, []net.IP{routed}, testLifetimes)...)
+		resp.Options.Options = append(resp.Options.Options, iaPDOptions(msg, []*net.IPNet{routedPrefix}, testLifetimes)...)
+
+		status := map[string]iana.StatusCode{"IA_NA": iana.StatusSuccess, "IA_TA": iana.StatusSuccess, "IA_PD": iana.StatusSuccess}
+		var leases []time.Duration
+		for _, ia := range resp.Options.IANA() {
+			if s := ia.Options.Status(); s != nil {
+				status["IA_NA"] = s.StatusCode
+			}
+			for _, a := range ia.Options.Addresses() {
+				leases = append(leases, a.ValidLifetime)
+			}
+		}
+		for _, ia := range resp.Options.IATA() {
+			if s := ia.Options.Status(); s != nil {
+				status["IA_TA"] = s.StatusCode
+			}
+			for _, a := range ia.Options.Addresses() {
+				leases = append(leases, a.ValidLifetime)
+			}
+		}
+		for _, ia := range resp.Options.IAPD() {
+			if s := ia.Options.Status(); s != nil {
+				status["IA_PD"] = s.StatusCode
+			}
+			for _, p := range ia.Options.Prefixes() {
+				leases = append(leases, p.ValidLifetime)
+			}
+		}
+
+		for ia, s := range status {
+			if s != tt.want {
+				t.Errorf("%s: %s got %v, want %v", tt.name, ia, s, tt.want)
+			}
+		}
+		for _, l := range leases {
+			// leases are given up with lifetimes of 0 on a Rebind, none at all on a Renew
+			if tt.want != iana.StatusSuccess && l != 0 {
+				t.Errorf("%s: lease handed out with %v", tt.name, l)
+			}
+		}
+		if tt.want == iana.StatusNotOnLink && len(leases) != 3 {
+			t.Errorf("%s: %d leases given up, want 3", tt.name, len(leases))
+		}
+	}
+}
+
+func TestAssignAddresses(t *testing.T) {
+	a1, a2, a3 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3")
+	id1, id2 := [4]byte{0, 0, 0, 1}, [4]byte{0, 0, 0, 2}
+
+	tests := []struct {
+		name  string
+		iaids [][4]byte
+		addrs []net.IP
+		mode  string
+		want  map[[4]byte][]net.IP
+	}{
+		{"all, one ia", [][4]byte{id1}, []net.IP{a3, a1, a2}, assignAll, map[[4]byte][]net.IP{id1: {a1, a2, a3}}},
+		{"all, round robin", [][4]byte{id2, id1}, []net.IP{a3, a2, a1}, assignAll, map[[4]byte][]net.IP{id1: {a1, a3}, id2: {a2}}},
+		{"single, one ia", [][4]byte{id1}, []net.IP{a2, a1}, assignSingle, map[[4]byte][]net.IP{id1: {a1}}},
+		{"single, one each", [][4]byte{id2, id1}, []net.IP{a3, a2, a1}, assignSingle, map[[4]byte][]net.IP{id1: {a1}, id2: {a2}}},
+		{"single, more ias than addresses", [][4]byte{id2, id1}, []net.IP{a1}, assignSingle, map[[4]byte][]net.IP{id1: {a1}}},
+		{"no ia", nil, []net.IP{a1}, assignAll, map[[4]byte][]net.IP{}},
+		{"no addresses", [][4]byte{id1}, nil, assignAll, map[[4]byte][]net.IP{}},
+	}
+	for _, tt := range tests {
+		got := assignAddresses(tt.iaids, tt.addrs, tt.mode)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for id, want := range tt.want {
+			if !equalIPs(got[id], want) {
+				t.Errorf("%s: IAID %v got %v, want %v", tt.name, id, got[id], want)
+			}
+		}
+	}
+}
+
+func TestIANAOptions(t *testing.T) {
+	a1, a2, a3 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3")
+	stale := net.ParseIP("2001:db8::99")
+	id1, id2 := [4]byte{0, 0, 0, 1}, [4]byte{0, 0, 0, 2}
+	routed := []net.IP{a3, a1, a2}
+
+	type ia struct {
+		id    [4]byte
+		addrs []net.IP
+	}
+	type answer struct {
+		addrs  []net.IP
+		valid  time.Duration
+		status iana.StatusCode
+	}
+	tests := []struct {
+		name      string
+		typ       dhcpv6.MessageType
+		ias       []ia
+		mode      string
+		want      map[[4]byte]answer
+		handedOut []net.IP
+	}{
+		{
+			"solicit, all, lowest iaid first", dhcpv6.MessageTypeSolicit,
+			[]ia{{id: id2}, {id: id1}}, assignAll,
+			map[[4]byte]answer{id1: {addrs: []net.IP{a1, a3}, valid: 2 * time.Hour}, id2: {addrs: []net.IP{a2}, valid: 2 * time.Hour}},
+			[]net.IP{a1, a3, a2},
+		},
+		{
+			"solicit, single", dhcpv6.MessageTypeSolicit,
+			[]ia{{id: id2}, {id: id1}}, assignSingle,
+			map[[4]byte]answer{id1: {addrs: []net.IP{a1}, valid: 2 * time.Hour}, id2: {addrs: []net.IP{a2}, valid: 2 * time.Hour}},
+			[]net.IP{a1, a2},
+		},
+		{
+			"request without ia_na", dhcpv6.MessageTypeRequest,
+			nil, assignSingle,
+			map[[4]byte]answer{{}: {addrs: []net.IP{a1}, valid: 2 * time.Hour}},
+			[]net.IP{a1},
+		},
+		{
+			"renew keeps what the client has", dhcpv6.MessageTypeRenew,
+			[]ia{{id: id1, addrs: []net.IP{a2}}}, assignAll,
+			map[[4]byte]answer{id1: {addrs: []net.IP{a2}, valid: 2 * time.Hour}},
+			[]net.IP{a2},
+		},
+		{
+			"renew stale", dhcpv6.MessageTypeRenew,
+			[]ia{{id: id1, addrs: []net.IP{a1, stale}}, {id: id2, addrs: []net.IP{a2}}}, assignAll,
+			map[[4]byte]answer{id1: {status: iana.StatusNoBinding}, id2: {addrs: []net.IP{a2}, valid: 2 * time.Hour}},
+			[]net.IP{a2},
+		},
+		{
+			"rebind stale", dhcpv6.MessageTypeRebind,
+			[]ia{{id: id1, addrs: []net.IP{stale}}}, assignAll,
+			map[[4]byte]answer{id1: {addrs: []net.IP{stale}, status: iana.StatusNotOnLink}},
+			nil,
+		},
+		{
+			"rebind without addresses", dhcpv6.MessageTypeRebind,
+			[]ia{{id: id1}}, assignSingle,
+			map[[4]byte]answer{id1: {addrs: []net.IP{a1}, valid: 2 * time.Hour}},
+			[]net.IP{a1},
+		},
+	}
+	for _, tt := range tests {
+		msg, _ := dhcpv6.NewMessage()
+		msg.MessageType = tt.typ
+		for _, i := range tt.ias {
+			o := &dhcpv6.OptIANA{IaId: i.id}
+			for _, a := range i.addrs {
+				o.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: a})
+			}
+			msg.AddOption(o)
+		}
+
+		opts, handedOut := iaNAOptions(msg, routed, tt.mode, testLifetimes)
+		if len(opts) != len(tt.want) {
+			t.Errorf("%s: got %d IA_NA, want %d", tt.name, len(opts), len(tt.want))
+			continue
+		}
+		for _, o := range opts {
+			r := o.(*dhcpv6.OptIANA)
+			want := tt.want[r.IaId]
+			var addrs []net.IP
+			for _, a := range r.Options.Addresses() {
+				addrs = append(addrs, a.IPv6Addr)
+				if a.ValidLifetime != want.valid {
+					t.Errorf("%s: IAID %v %v valid for %v, want %v", tt.name, r.IaId, a.IPv6Addr, a.ValidLifetime, want.valid)
+				}
+			}
+			if !equalIPs(addrs, want.addrs) {
+				t.Errorf("%s: IAID %v got %v, want %v", tt.name, r.IaId, addrs, want.addrs)
+			}
+			status := iana.StatusSuccess
+			if s := r.Options.Status(); s != nil {
+				status = s.StatusCode
+			}
+			if status != want.status {
+				t.Errorf("%s: IAID %v got status %v, want %v", tt.name, r.IaId, status, want.status)
+			}
+		}
+		if !equalIPs(handedOut, tt.handedOut) {
+			t.Errorf("%s: handed out %v, want %v", tt.name, handedOut, tt.handedOut)
+		}
+	}
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
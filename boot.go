@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// boot targets, each with its own boot file url and parameters
+const (
+	bootIPXE = "ipxe"
+	bootUEFI = "uefi"
+	bootBIOS = "bios"
+)
+
+var bootTargets = []string{bootIPXE, bootUEFI, bootBIOS}
+
+// bootDefault is the boot url for clients nothing else matches
+const bootDefault = "default"
+
+// IsUsingUEFI checks if msg comes from UEFI firmware by its architecture types or an "EFI" user class
+func IsUsingUEFI(msg *dhcpv6.Message) bool {
+	for _, a := range msg.Options.ArchTypes() {
+		if isEFIArch(a) {
+			return true
+		}
+	}
+	for _, uc := range userClasses(msg) {
+		if strings.Contains(uc, "EFI") {
+			return true
+		}
+	}
+	return false
+}
+
+// efiArchs are the UEFI architecture types of RFC 4578 and the IANA registry, PXE and HTTP boot alike
+var efiArchs = map[iana.Arch]bool{
+	iana.EFI_ITANIUM:       true,
+	iana.EFI_IA32:          true,
+	iana.EFI_X86_64:        true,
+	iana.EFI_XSCALE:        true,
+	iana.EFI_BC:            true,
+	iana.EFI_ARM32:         true,
+	iana.EFI_ARM64:         true,
+	iana.EFI_X86_HTTP:      true,
+	iana.EFI_X86_64_HTTP:   true,
+	iana.EFI_BC_HTTP:       true,
+	iana.EFI_ARM32_HTTP:    true,
+	iana.EFI_ARM64_HTTP:    true,
+	iana.EFI_RISCV32:       true,
+	iana.EFI_RISCV32_HTTP:  true,
+	iana.EFI_RISCV64:       true,
+	iana.EFI_RISCV64_HTTP:  true,
+	iana.EFI_RISCV128:      true,
+	iana.EFI_RISCV128_HTTP: true,
+	iana.EFI_MIPS32:        true,
+	iana.EFI_MIPS64:        true,
+	iana.EFI_SUNWAY32:      true,
+	iana.EFI_SUNWAY64:      true,
+}
+
+// isEFIArch checks if a is one of the UEFI architecture types
+func isEFIArch(a iana.Arch) bool {
+	return efiArchs[a]
+}
+
+// bootTarget returns what msg is booted by, iPXE only counts if there is something to chain to
+func bootTarget(msg *dhcpv6.Message, urls map[string]string) string {
+	userClass := ""
+	if msg.Options.GetOne(dhcpv6.OptionUserClass) != nil {
+		userClass = msg.Options.GetOne(dhcpv6.OptionUserClass).String()
+	}
+	if urls[bootIPXE] != "" && strings.Contains(userClass, "iPXE") {
+		return bootIPXE
+	}
+	if IsUsingUEFI(msg) {
+		return bootUEFI
+	}
+	return bootBIOS
+}
+
+// bootURL returns the boot file url for msg booted by target. iPXE gets its url, anything else the url
+// of the first of its architecture types having one, then the one of its target and eventually the default
+func bootURL(msg *dhcpv6.Message, target string, urls map[string]string) string {
+	if target == bootIPXE {
+		return urls[bootIPXE]
+	}
+	for _, a := range msg.Options.ArchTypes() {
+		if u := urls[strconv.Itoa(int(a))]; u != "" {
+			return u
+		}
+	}
+	if u := urls[target]; u != "" {
+		return u
+	}
+	return urls[bootDefault]
+}
+
+func validBootTarget(t string) bool {
+	for _, b := range bootTargets {
+		if t == b {
+			return true
+		}
+	}
+	return false
+}
+
+// validBootURLKey checks k is an architecture type number, a boot target or the default
+func validBootURLKey(k string) error {
+	if validBootTarget(k) || k == bootDefault {
+		return nil
+	}
+	if _, err := strconv.ParseUint(k, 10, 16); err != nil {
+		return fmt.Errorf("'%s' is neither an architecture type number nor one of %v or %s", k, bootTargets, bootDefault)
+	}
+	return nil
+}
+
+// BootVars are the per client variables boot urls are rendered with, i.e. http://boot/{{.Interface}}?ip={{.IP}}.
+// all of them are url escaped, those the client didn't send are empty
+type BootVars struct {
+	IP        string
+	Interface string
+	MAC       string
+	DUID      string
+	IAID      string
+	Arch      string
+	UserClass string
+	Hostname  string
+	FQDN      string
+}
+
+// newBootVars collects the variables of msg received on ifName from mac, ip is the address handed out (if any)
+func newBootVars(msg *dhcpv6.Message, ifName string, ip net.IP, mac net.HardwareAddr, fqdn string) BootVars {
+	v := BootVars{
+		Interface: ifName,
+		UserClass: strings.Join(userClasses(msg), ","),
+		FQDN:      fqdn,
+		Hostname:  strings.SplitN(fqdn, ".", 2)[0],
+	}
+	if ip != nil {
+		v.IP = ip.String()
+	}
+	if len(mac) > 0 {
+		v.MAC = mac.String()
+	}
+	if cid := msg.Options.ClientID(); cid != nil {
+		v.DUID = hex.EncodeToString(cid.ToBytes())
+	}
+	if ias := msg.Options.IANA(); len(ias) > 0 {
+		iaids := make([][4]byte, len(ias))
+		for i, ia := range ias {
+			iaids[i] = ia.IaId
+		}
+		id := lowestIAID(iaids)
+		v.IAID = hex.EncodeToString(id[:])
+	}
+	if archs := msg.Options.ArchTypes(); len(archs) > 0 {
+		v.Arch = strconv.Itoa(int(archs[0]))
+	}
+
+	for _, s := range []*string{&v.IP, &v.Interface, &v.MAC, &v.DUID, &v.IAID, &v.Arch, &v.UserClass, &v.Hostname, &v.FQDN} {
+		*s = url.QueryEscape(*s)
+	}
+	return v
+}
+
+// renderBootURL executes the template u with v, urls without any {{ }} are returned as they are
+func renderBootURL(u string, v BootVars) (string, error) {
+	t, err := template.New("boot-url").Option("missingkey=error").Parse(u)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validBootURL checks u renders, an unknown variable only shows up when executing it
+func validBootURL(u string) error {
+	if _, err := renderBootURL(u, BootVars{}); err != nil {
+		return fmt.Errorf("invalid boot url template %s: %w", u, err)
+	}
+	return nil
+}
+
+// mapBootURL maps architecture types, boot targets or the default to boot urls
+type mapBootURL map[string]string
+
+func (m *mapBootURL) String() string {
+	var s []string
+	for k, v := range *m {
+		s = append(s, k+"="+v)
+	}
+	return strings.Join(s, " ")
+}
+
+func (m *mapBootURL) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return fmt.Errorf("invalid boot url %s, expected <arch>=<url>", value)
+	}
+	if err := validBootURLKey(kv[0]); err != nil {
+		return err
+	}
+	if err := validBootURL(kv[1]); err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = mapBootURL{}
+	}
+	(*m)[kv[0]] = kv[1]
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestNewBootVars(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}}))
+	msg.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 2}})
+	msg.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}})
+	msg.AddOption(dhcpv6.OptClientArchType(iana.EFI_X86_64_HTTP, iana.EFI_X86_64))
+	// anything a client sends may carry characters breaking the url
+	msg.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte("iPXE"), []byte("a b&c=d/e?")}})
+
+	v := newBootVars(msg, "eth0", net.ParseIP("2001:db8::1"), net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, "host-1.example.com")
+	want := BootVars{
+		IP:        "2001%3Adb8%3A%3A1",
+		Interface: "eth0",
+		MAC:       "00%3A11%3A22%3A33%3A44%3A55",
+		DUID:      "00030001001122334455",
+		IAID:      "00000001",
+		Arch:      "16",
+		UserClass: "iPXE%2Ca+b%26c%3Dd%2Fe%3F",
+		Hostname:  "host-1",
+		FQDN:      "host-1.example.com",
+	}
+	if v != want {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+
+	// nothing sent, nothing handed out
+	empty, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := newBootVars(empty, "eth0", nil, nil, ""); v != (BootVars{Interface: "eth0"}) {
+		t.Errorf("got %+v for an empty message", v)
+	}
+}
+
+func TestRenderBootURL(t *testing.T) {
+	v := BootVars{IP: "2001%3Adb8%3A%3A1", Interface: "eth0", UserClass: "a+b%26c", FQDN: "host-1.example.com"}
+	tests := []struct {
+		name  string
+		url   string
+		want  string
+		fails bool
+	}{
+		{"no template", "http://boot.example.com/ipxe.efi", "http://boot.example.com/ipxe.efi", false},
+		{"variables", "http://boot/{{.Interface}}?ip={{.IP}}&uc={{.UserClass}}", "http://boot/eth0?ip=2001%3Adb8%3A%3A1&uc=a+b%26c", false},
+		{"empty variable", "http://boot/?mac={{.MAC}}", "http://boot/?mac=", false},
+		{"unknown variable", "http://boot/{{.Serial}}", "", true},
+		{"lower case variable", "http://boot/{{.fqdn}}", "", true},
+		{"unterminated action", "http://boot/{{.IP", "", true},
+	}
+	for _, tt := range tests {
+		got, err := renderBootURL(tt.url, v)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: rendered %q, want an error", tt.name, got)
+			}
+			if validBootURL(tt.url) == nil {
+				t.Errorf("%s: validBootURL accepted it", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Config is the optional configuration read from -config, it overrides the flags globally and for matching prefixes and interfaces
+type Config struct {
+	Global     Settings          `json:"global"`
+	Prefixes   []PrefixConfig    `json:"prefixes"`
+	Interfaces []InterfaceConfig `json:"interfaces"`
+}
+
+// PrefixConfig applies its Settings to addresses and delegated prefixes within CIDR
+type PrefixConfig struct {
+	CIDR string `json:"cidr"`
+	Settings
+
+	prefix *net.IPNet
+}
+
+// InterfaceConfig applies its Settings to every interface whose name matches Match
+type InterfaceConfig struct {
+	Match string `json:"match"`
+	Settings
+
+	regex *regexp.Regexp
+}
+
+// Settings are the knobs that can be set per scope, anything left out keeps the value of the wider scope
+type Settings struct {
+	StatelessOnly     *bool         `json:"stateless_only,omitempty"`
+	RapidCommit       *bool         `json:"rapid_commit,omitempty"`
+	Preference        *uint8        `json:"preference,omitempty"`
+	FQDNPolicy        *string       `json:"fqdn_policy,omitempty"`
+	SearchDomains     []string      `json:"search_domains,omitempty"`
+	NTPServers        []string      `json:"ntp_servers,omitempty"`
+	DNR               []DNRResolver `json:"dnr,omitempty"`
+	AFTRName          *string       `json:"aftr_name,omitempty"`
+	S46               *S46Config    `json:"s46,omitempty"`
+	SolMaxRT          *Duration     `json:"sol_max_rt,omitempty"`
+	InfMaxRT          *Duration     `json:"inf_max_rt,omitempty"`
+	InfoRefreshTime   *Duration     `json:"information_refresh_time,omitempty"`
+	PreferredLifetime *Duration     `json:"preferred_lifetime,omitempty"`
+	ValidLifetime     *Duration     `json:"valid_lifetime,omitempty"`
+	T1                *Duration     `json:"t1,omitempty"`
+	T2                *Duration     `json:"t2,omitempty"`
+
+	// Vendors replaces the Vendor Class and Vendor-specific Information entries of the wider scope
+	Vendors []VendorConfig `json:"vendors,omitempty"`
+
+	// Options are added to the ones of wider scopes, replacing those with the same code
+	Options []CustomOption `json:"options,omitempty"`
+
+	// BootParams replaces the boot file parameters per boot target (ipxe, uefi, bios)
+	BootParams map[string][]string `json:"boot_params,omitempty"`
+
+	// BootURLs replaces the boot file urls per architecture type number, boot target or default
+	BootURLs map[string]string `json:"boot_urls,omitempty"`
+}
+
+// settings are the effective settings a request is answered with
+type settings struct {
+	statelessOnly bool
+	rapidCommit   bool
+	preference    uint8
+	fqdnPolicy    string
+	searchDomains []string
+	ntpServers    []string
+	dnr           []DNRResolver
+	aftrName      string
+	s46           *S46Config
+	bootParams    map[string][]string
+	bootURLs      map[string]string
+	solMaxRT      time.Duration
+	infMaxRT      time.Duration
+	infoRefresh   time.Duration
+	options       []CustomOption
+	vendors       []VendorConfig
+	lifetimes     lifetimes
+}
+
+// loadConfig reads and validates the configuration file at path, an empty path is an empty configuration
+func loadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		return c, c.validate()
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %w", err)
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("unable to parse config %s: %w", path, err)
+	}
+	for i := range c.Prefixes {
+		_, n, err := net.ParseCIDR(c.Prefixes[i].CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix '%s': %w", c.Prefixes[i].CIDR, err)
+		}
+		c.Prefixes[i].prefix = n
+	}
+	for _, s := range c.scopes() {
+		for i := range s.Options {
+			if err := s.Options[i].build(); err != nil {
+				return nil, err
+			}
+		}
+		for i := range s.Vendors {
+			if err := s.Vendors[i].build(); err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range s.SearchDomains {
+			if err := validDomainName(d); err != nil {
+				return nil, fmt.Errorf("invalid search domain '%s': %w", d, err)
+			}
+		}
+		if s.AFTRName != nil && *s.AFTRName != "" {
+			if err := validDomainName(*s.AFTRName); err != nil {
+				return nil, fmt.Errorf("invalid aftr name '%s': %w", *s.AFTRName, err)
+			}
+		}
+		if s.S46 != nil {
+			if err := s.S46.validate(); err != nil {
+				return nil, fmt.Errorf("invalid s46: %w", err)
+			}
+		}
+		for k, u := range s.BootURLs {
+			if err := validBootURLKey(k); err != nil {
+				return nil, fmt.Errorf("invalid boot_urls: %w", err)
+			}
+			if err := validBootURL(u); err != nil {
+				return nil, fmt.Errorf("invalid boot_urls: %w", err)
+			}
+		}
+		for t := range s.BootParams {
+			if !validBootTarget(t) {
+				return nil, fmt.Errorf("invalid boot target '%s' in boot_params. Valid targets are %v", t, bootTargets)
+			}
+		}
+		for _, n := range s.NTPServers {
+			if err := validNTPServer(n); err != nil {
+				return nil, fmt.Errorf("invalid ntp server '%s': %w", n, err)
+			}
+		}
+	}
+	for i := range c.Interfaces {
+		r, err := regexp.Compile(c.Interfaces[i].Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface match '%s': %w", c.Interfaces[i].Match, err)
+		}
+		c.Interfaces[i].regex = r
+	}
+	return c, c.validate()
+}
+
+// scopes returns the settings of every scope
+func (c *Config) scopes() []*Settings {
+	s := []*Settings{&c.Global}
+	for i := range c.Prefixes {
+		s = append(s, &c.Prefixes[i].Settings)
+	}
+	for i := range c.Interfaces {
+		s = append(s, &c.Interfaces[i].Settings)
+	}
+	return s
+}
+
+// validate resolves every combination of scopes and makes sure the result is sane
+func (c *Config) validate() error {
+	prefixes := append([]PrefixConfig{{CIDR: "flags"}}, c.Prefixes...)
+	interfaces := append([]InterfaceConfig{{Match: "flags"}}, c.Interfaces...)
+	for _, p := range prefixes {
+		for _, i := range interfaces {
+			if err := resolveSettings(c.Global, p.Settings, i.Settings).validate(); err != nil {
+				return fmt.Errorf("prefix %s, interface %s: %w", p.CIDR, i.Match, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the settings make sense to clients
+func (s settings) validate() error {
+	if err := s.lifetimes.validate(); err != nil {
+		return err
+	}
+	if err := validFQDNPolicy(s.fqdnPolicy); err != nil {
+		return err
+	}
+	// RFC 8415 21.24 and 21.25, clients ignore anything out of range. 0 leaves the option out
+	for name, d := range map[string]time.Duration{"sol_max_rt": s.solMaxRT, "inf_max_rt": s.infMaxRT} {
+		if d != 0 && (d < time.Minute || d > 24*time.Hour) {
+			return fmt.Errorf("%s %s has to be within 60s and 86400s", name, d)
+		}
+	}
+	// RFC 8415 21.23, IRT_MINIMUM
+	if s.infoRefresh != 0 && s.infoRefresh < 10*time.Minute {
+		return fmt.Errorf("information refresh time %s has to be at least 600s", s.infoRefresh)
+	}
+	return nil
+}
+
+// apply overrides everything set in s
+func (s Settings) apply(to *settings) {
+	if s.StatelessOnly != nil {
+		to.statelessOnly = *s.StatelessOnly
+	}
+	if s.RapidCommit != nil {
+		to.rapidCommit = *s.RapidCommit
+	}
+	if s.Preference != nil {
+		to.preference = *s.Preference
+	}
+	if s.FQDNPolicy != nil {
+		to.fqdnPolicy = *s.FQDNPolicy
+	}
+	if s.SearchDomains != nil {
+		to.searchDomains = s.SearchDomains
+	}
+	if s.NTPServers != nil {
+		to.ntpServers = s.NTPServers
+	}
+	if s.DNR != nil {
+		to.dnr = s.DNR
+	}
+	if s.AFTRName != nil {
+		to.aftrName = *s.AFTRName
+	}
+	if s.S46 != nil {
+		to.s46 = s.S46
+	}
+	if s.BootURLs != nil {
+		urls := map[string]string{}
+		for k, u := range to.bootURLs {
+			urls[k] = u
+		}
+		for k, u := range s.BootURLs {
+			urls[k] = u
+		}
+		to.bootURLs = urls
+	}
+	if s.BootParams != nil {
+		params := map[string][]string{}
+		for t, p := range to.bootParams {
+			params[t] = p
+		}
+		for t, p := range s.BootParams {
+			params[t] = p
+		}
+		to.bootParams = params
+	}
+	if s.SolMaxRT != nil {
+		to.solMaxRT = s.SolMaxRT.Duration
+	}
+	if s.InfMaxRT != nil {
+		to.infMaxRT = s.InfMaxRT.Duration
+	}
+	if s.InfoRefreshTime != nil {
+		to.infoRefresh = s.InfoRefreshTime.Duration
+	}
+	if s.Vendors != nil {
+		to.vendors = s.Vendors
+	}
+	to.options = mergeOptions(to.options, s.Options)
+	if s.PreferredLifetime != nil {
+		to.lifetimes.preferred = s.PreferredLifetime.Duration
+	}
+	if s.ValidLifetime != nil {
+		to.lifetimes.valid = s.ValidLifetime.Duration
+	}
+	if s.T1 != nil {
+		to.lifetimes.t1 = s.T1.Duration
+	}
+	if s.T2 != nil {
+		to.lifetimes.t2 = s.T2.Duration
+	}
+}
+
+// flagSettings are the settings as given on the command line
+func flagSettings() settings {
+	search := []string(searchDomains)
+	if len(search) == 0 {
+		search = []string{*flagDomainname}
+	}
+	return settings{
+		searchDomains: search,
+		ntpServers:    ntpServers,
+		dnr:           dnrResolvers,
+		aftrName:      *flagAFTRName,
+		bootURLs:      bootURLs,
+		bootParams: map[string][]string{
+			bootIPXE: ipxeParams,
+			bootUEFI: uefiParams,
+			bootBIOS: biosParams,
+		},
+		solMaxRT:    *flagSolMaxRT,
+		infMaxRT:    *flagInfMaxRT,
+		infoRefresh: *flagInfoRefresh,
+		rapidCommit: *flagRapidCommit,
+		preference:  uint8(*flagPreference),
+		fqdnPolicy:  *flagFQDNPolicy,
+		vendors:     defaultVendors,
+		lifetimes: lifetimes{
+			preferred: *flagPreferredLifetime,
+			valid:     *flagValidLifetime,
+			t1:        *flagT1,
+			t2:        *flagT2,
+		},
+	}
+}
+
+// resolveSettings applies scopes on top of the flags, lifetimes not set anywhere are derived last
+func resolveSettings(scopes ...Settings) settings {
+	s := flagSettings()
+	for _, sc := range scopes {
+		sc.apply(&s)
+	}
+	s.lifetimes = s.lifetimes.derive()
+	return s
+}
+
+// Settings returns the settings for requests received on ifName regarding n, n may be nil if it is about no address at all.
+// flags are the defaults overridden by the global scope, a prefix scope containing n comes next and matching interface scopes win.
+// scopes of the same kind are applied in the order they are configured
+func (c *Config) Settings(ifName string, n *net.IPNet) settings {
+	scopes := []Settings{c.Global}
+	if n != nil {
+		for _, p := range c.Prefixes {
+			if p.prefix.Contains(n.IP) {
+				scopes = append(scopes, p.Settings)
+			}
+		}
+	}
+	for _, i := range c.Interfaces {
+		if i.regex.MatchString(ifName) {
+			scopes = append(scopes, i.Settings)
+		}
+	}
+	return resolveSettings(scopes...)
+}
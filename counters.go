@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counters keeps running totals of noteworthy events (i.e. why messages got dropped) - thread safe
+type Counters struct {
+	c    map[string]uint64
+	lock sync.Mutex
+}
+
+// stats collects the counters of all listeners, dumped to the log on SIGUSR1
+var stats = NewCounters()
+
+// NewCounters just sets up an empty set of counters
+func NewCounters() *Counters {
+	return &Counters{
+		c: make(map[string]uint64),
+	}
+}
+
+// Inc increments the counter name and returns its new value
+func (c *Counters) Inc(name string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.c[name]++
+	return c.c[name]
+}
+
+// String returns all counters sorted by name
+func (c *Counters) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	names := make([]string, 0, len(c.c))
+	for k := range c.c {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	s := make([]string, len(names))
+	for i, n := range names {
+		s[i] = fmt.Sprintf("%s=%d", n, c.c[n])
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// options the server builds itself from the request, they can't be defined in the config
+var reservedOptions = map[dhcpv6.OptionCode]bool{
+	dhcpv6.OptionClientID:      true,
+	dhcpv6.OptionServerID:      true,
+	dhcpv6.OptionIANA:          true,
+	dhcpv6.OptionIATA:          true,
+	dhcpv6.OptionIAAddr:        true,
+	dhcpv6.OptionORO:           true,
+	dhcpv6.OptionElapsedTime:   true,
+	dhcpv6.OptionRelayMsg:      true,
+	dhcpv6.OptionAuth:          true,
+	dhcpv6.OptionStatusCode:    true,
+	dhcpv6.OptionRapidCommit:   true,
+	dhcpv6.OptionInterfaceID:   true,
+	dhcpv6.OptionReconfMessage: true,
+	dhcpv6.OptionReconfAccept:  true,
+	dhcpv6.OptionIAPD:          true,
+	dhcpv6.OptionIAPrefix:      true,
+}
+
+// encoders for the value types of custom options
+var optionTypes = map[string]func(values []string) ([]byte, error){
+	"ipv6-list": func(values []string) ([]byte, error) {
+		var b []byte
+		for _, v := range values {
+			ip := net.ParseIP(v)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("invalid IPv6 address %s", v)
+			}
+			b = append(b, ip.To16()...)
+		}
+		return b, nil
+	},
+	"string": func(values []string) ([]byte, error) {
+		return []byte(strings.Join(values, "")), nil
+	},
+	"fqdn": func(values []string) ([]byte, error) {
+		for _, v := range values {
+			if err := validDomainName(v); err != nil {
+				return nil, err
+			}
+		}
+		return (&rfc1035label.Labels{Labels: values}).ToBytes(), nil
+	},
+	"uint8":  uintEncoder(8),
+	"uint16": uintEncoder(16),
+	"uint32": uintEncoder(32),
+	"hex": func(values []string) ([]byte, error) {
+		return hex.DecodeString(strings.NewReplacer(":", "", "-", "", " ", "").Replace(strings.Join(values, "")))
+	},
+}
+
+func getOptionTypes() []string {
+	var types []string
+	for k := range optionTypes {
+		types = append(types, k)
+	}
+	return types
+}
+
+func uintEncoder(bits int) func(values []string) ([]byte, error) {
+	return func(values []string) ([]byte, error) {
+		var b []byte
+		for _, v := range values {
+			n, err := strconv.ParseUint(v, 0, bits)
+			if err != nil {
+				return nil, fmt.Errorf("invalid uint%d %s: %w", bits, v, err)
+			}
+			e := make([]byte, 4)
+			binary.BigEndian.PutUint32(e, uint32(n))
+			b = append(b, e[4-bits/8:]...)
+		}
+		return b, nil
+	}
+}
+
+// OptionValue is a single value or a list of values, numbers are taken as their string representation
+type OptionValue []string
+
+// UnmarshalJSON accepts a string, a number or a list of them
+func (v *OptionValue) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var raw interface{}
+	if err := d.Decode(&raw); err != nil {
+		return err
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		list = []interface{}{raw}
+	}
+	for _, e := range list {
+		switch e := e.(type) {
+		case string:
+			*v = append(*v, e)
+		case json.Number:
+			*v = append(*v, e.String())
+		default:
+			return fmt.Errorf("option value has to be a string, a number or a list of them")
+		}
+	}
+	return nil
+}
+
+// CustomOption is an option defined in the config
+type CustomOption struct {
+	Code   uint16      `json:"code"`
+	Type   string      `json:"type"`
+	Value  OptionValue `json:"value"`
+	Always bool        `json:"always,omitempty"`
+
+	option dhcpv6.Option
+}
+
+// build validates and encodes the option
+func (o *CustomOption) build() error {
+	code := dhcpv6.OptionCode(o.Code)
+	if o.Code == 0 || reservedOptions[code] {
+		return fmt.Errorf("option code %d can't be set", o.Code)
+	}
+	data, err := encodeOption(o.Code, o.Type, o.Value)
+	if err != nil {
+		return err
+	}
+	o.option = &dhcpv6.OptionGeneric{OptionCode: code, OptionData: data}
+	return nil
+}
+
+// encodeOption encodes the values of option code as typ
+func encodeOption(code uint16, typ string, values []string) ([]byte, error) {
+	enc, ok := optionTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("invalid type '%s' for option %d. Valid types are %v", typ, code, getOptionTypes())
+	}
+	data, err := enc(values)
+	if err != nil {
+		return nil, fmt.Errorf("option %d: %w", code, err)
+	}
+	return data, nil
+}
+
+// hasOption checks if an option with code is defined in the config
+func (s settings) hasOption(code dhcpv6.OptionCode) bool {
+	for _, o := range s.options {
+		if dhcpv6.OptionCode(o.Code) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOptions adds opts to base, an option of the same code replaces the one of the wider scope
+func mergeOptions(base, opts []CustomOption) []CustomOption {
+	r := append([]CustomOption{}, base...)
+	for _, o := range opts {
+		replaced := false
+		for i := range r {
+			if r[i].Code == o.Code {
+				r[i] = o
+				replaced = true
+			}
+		}
+		if !replaced {
+			r = append(r, o)
+		}
+	}
+	return r
+}
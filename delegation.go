@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// iaPDOptions answers every IA_PD of msg. The first IA_PD gets all routed prefixes delegated,
+// any further one is told there is nothing left
+func iaPDOptions(msg *dhcpv6.Message, prefixes []*net.IPNet, lt lifetimesFunc) []dhcpv6.Option {
+	var opts []dhcpv6.Option
+	for i, ia := range msg.Options.IAPD() {
+		r := &dhcpv6.OptIAPD{IaId: ia.IaId}
+
+		var clientPrefixes []*net.IPNet
+		for _, p := range ia.Options.Prefixes() {
+			if p.Prefix != nil {
+				clientPrefixes = append(clientPrefixes, p.Prefix)
+			}
+		}
+		switch b := iaBinding(msg.Type(), len(clientPrefixes), !containsAllPrefixes(prefixes, clientPrefixes)); {
+		case b == bindingUnknown:
+			r.Options.Add(b.status())
+		case b == bindingGone:
+			// returning the prefixes with lifetimes of 0 makes the client drop them right away
+			for _, p := range clientPrefixes {
+				r.Options.Add(&dhcpv6.OptIAPrefix{Prefix: p})
+			}
+			r.Options.Add(b.status())
+		case i > 0 || len(prefixes) == 0:
+			r.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoPrefixAvail, StatusMessage: "no prefixes available"})
+		default:
+			// a known binding gets everything routed, which includes what the client has
+			var plt []lifetimes
+			for _, p := range prefixes {
+				l := lt(p)
+				plt = append(plt, l)
+				r.Options.Add(&dhcpv6.OptIAPrefix{
+					PreferredLifetime: l.preferred,
+					ValidLifetime:     l.valid,
+					Prefix:            p,
+				})
+			}
+			r.T1, r.T2 = iaTimers(plt)
+		}
+		opts = append(opts, r)
+	}
+	return opts
+}
+
+// containsAllPrefixes checks if every prefix of want is part of have
+func containsAllPrefixes(have []*net.IPNet, want []*net.IPNet) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h.String() == w.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// optionV6DNR is the Encrypted DNS option (RFC 9463), unknown to the dhcpv6 library
+const optionV6DNR dhcpv6.OptionCode = 144
+
+// SvcParamKeys (RFC 9460 14.3.2) used in DNR
+const (
+	svcParamALPN    = 1
+	svcParamPort    = 3
+	svcParamDoHPath = 7
+)
+
+// DNRResolver is an encrypted DNS resolver, written like a SVCB record:
+// "<adn> [priority=N] [addrs=ip,...] [alpn=id,...] [port=N] [dohpath=template]"
+type DNRResolver struct {
+	ADN      string
+	Priority uint16
+	Addrs    []net.IP
+	ALPN     []string
+	Port     uint16
+	DoHPath  string
+}
+
+// parseDNR parses and validates the textual form of a resolver
+func parseDNR(s string) (DNRResolver, error) {
+	var r DNRResolver
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return r, fmt.Errorf("empty resolver")
+	}
+	if err := validDomainName(fields[0]); err != nil {
+		return r, fmt.Errorf("invalid authentication domain name: %w", err)
+	}
+	r.ADN = strings.TrimSuffix(fields[0], ".")
+
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return r, fmt.Errorf("invalid parameter '%s', expected key=value", f)
+		}
+		switch kv[0] {
+		case "priority":
+			p, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil || p == 0 {
+				return r, fmt.Errorf("invalid priority %s, has to be within 1 and 65535", kv[1])
+			}
+			r.Priority = uint16(p)
+		case "addrs":
+			for _, a := range strings.Split(kv[1], ",") {
+				ip := net.ParseIP(a)
+				if ip == nil || ip.To4() != nil {
+					return r, fmt.Errorf("invalid IPv6 address %s", a)
+				}
+				r.Addrs = append(r.Addrs, ip)
+			}
+		case "alpn":
+			for _, id := range strings.Split(kv[1], ",") {
+				if id == "" || len(id) > 255 {
+					return r, fmt.Errorf("invalid alpn id '%s'", id)
+				}
+				r.ALPN = append(r.ALPN, id)
+			}
+		case "port":
+			p, err := strconv.ParseUint(kv[1], 10, 16)
+			if err != nil || p == 0 {
+				return r, fmt.Errorf("invalid port %s", kv[1])
+			}
+			r.Port = uint16(p)
+		case "dohpath":
+			// RFC 9461 5, a relative URI template using the "dns" variable
+			if !strings.HasPrefix(kv[1], "/") || !strings.Contains(kv[1], "{?dns}") {
+				return r, fmt.Errorf("invalid dohpath %s, has to start with / and contain {?dns}", kv[1])
+			}
+			r.DoHPath = kv[1]
+		default:
+			return r, fmt.Errorf("unknown parameter '%s'", kv[0])
+		}
+	}
+
+	// RFC 9463 3.1.6, without addresses it is ADN-only and carries no service parameters
+	if len(r.Addrs) == 0 && (len(r.ALPN) > 0 || r.Port != 0 || r.DoHPath != "") {
+		return r, fmt.Errorf("resolver %s has service parameters but no addrs", r.ADN)
+	}
+	if len(r.Addrs) > 0 && len(r.ALPN) == 0 {
+		return r, fmt.Errorf("resolver %s needs alpn when addrs are given", r.ADN)
+	}
+	return r, nil
+}
+
+// String returns the resolver in the form parseDNR takes
+func (r DNRResolver) String() string {
+	s := []string{r.ADN}
+	if r.Priority != 0 {
+		s = append(s, fmt.Sprintf("priority=%d", r.Priority))
+	}
+	if len(r.Addrs) > 0 {
+		var addrs []string
+		for _, a := range r.Addrs {
+			addrs = append(addrs, a.String())
+		}
+		s = append(s, "addrs="+strings.Join(addrs, ","))
+	}
+	if len(r.ALPN) > 0 {
+		s = append(s, "alpn="+strings.Join(r.ALPN, ","))
+	}
+	if r.Port != 0 {
+		s = append(s, fmt.Sprintf("port=%d", r.Port))
+	}
+	if r.DoHPath != "" {
+		s = append(s, "dohpath="+r.DoHPath)
+	}
+	return strings.Join(s, " ")
+}
+
+// UnmarshalJSON takes the resolver in the same form as the -dnr flag
+func (r *DNRResolver) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	p, err := parseDNR(s)
+	if err != nil {
+		return fmt.Errorf("invalid dnr resolver '%s': %w", s, err)
+	}
+	*r = p
+	return nil
+}
+
+// option encodes the resolver as DNR option with the given priority
+func (r DNRResolver) option(priority uint16) dhcpv6.Option {
+	adn := (&rfc1035label.Labels{Labels: []string{r.ADN}}).ToBytes()
+	b := appendUint16(nil, priority)
+	b = appendUint16(b, uint16(len(adn)))
+	b = append(b, adn...)
+	if len(r.Addrs) == 0 {
+		return &dhcpv6.OptionGeneric{OptionCode: optionV6DNR, OptionData: b}
+	}
+
+	b = appendUint16(b, uint16(16*len(r.Addrs)))
+	for _, a := range r.Addrs {
+		b = append(b, a.To16()...)
+	}
+	// SvcParams in increasing key order
+	var alpn []byte
+	for _, id := range r.ALPN {
+		alpn = append(alpn, byte(len(id)))
+		alpn = append(alpn, id...)
+	}
+	b = appendSvcParam(b, svcParamALPN, alpn)
+	if r.Port != 0 {
+		b = appendSvcParam(b, svcParamPort, appendUint16(nil, r.Port))
+	}
+	if r.DoHPath != "" {
+		b = appendSvcParam(b, svcParamDoHPath, []byte(r.DoHPath))
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: optionV6DNR, OptionData: b}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendSvcParam(b []byte, key uint16, value []byte) []byte {
+	b = appendUint16(b, key)
+	b = appendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// dnrOptions returns one DNR option per resolver in the order mixDNS would use for ip.
+// resolvers without a configured priority are prioritized by that order
+func dnrOptions(ip net.IP, resolvers []DNRResolver) []dhcpv6.Option {
+	l := len(resolvers)
+	if l == 0 {
+		return nil
+	}
+	m := mixOffset(ip, l)
+	var opts []dhcpv6.Option
+	for i := 0; i < l; i++ {
+		r := resolvers[(i+m)%l]
+		p := r.Priority
+		if p == 0 {
+			p = uint16(i + 1)
+		}
+		opts = append(opts, r.option(p))
+	}
+	return opts
+}
+
+// listDNR is a list of encrypted DNS resolvers
+type listDNR []DNRResolver
+
+func (d *listDNR) String() string {
+	var s []string
+	for _, r := range *d {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+func (d *listDNR) Set(value string) error {
+	r, err := parseDNR(value)
+	if err != nil {
+		return fmt.Errorf("invalid dnr resolver %s: %v", value, err)
+	}
+	*d = append(*d, r)
+	return nil
+}
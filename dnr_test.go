@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDNROption(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver string
+		priority uint16
+		want     []byte
+	}{
+		// RFC 9463 4.1: service priority, ADN length, ADN, and nothing else in ADN-only mode
+		{
+			"adn only",
+			"resolver.example",
+			10,
+			[]byte{
+				0x00, 0x90, 0x00, 0x16,
+				0x00, 0x0a,
+				0x00, 0x12,
+				8, 'r', 'e', 's', 'o', 'l', 'v', 'e', 'r', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0,
+			},
+		},
+		// with addresses: addr length, addresses, then SvcParams (RFC 9460 2.2) in increasing key order,
+		// alpn ids are prefixed by their length
+		{
+			"addresses and svcparams",
+			"dns.example addrs=2001:db8::1,2001:db8::2 alpn=h2,dot port=443 dohpath=/q{?dns}",
+			1,
+			[]byte{
+				0x00, 0x90, 0x00, 0x50,
+				0x00, 0x01,
+				0x00, 0x0d,
+				3, 'd', 'n', 's', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0,
+				0x00, 0x20,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02,
+				0x00, 0x01, 0x00, 0x07, 2, 'h', '2', 3, 'd', 'o', 't',
+				0x00, 0x03, 0x00, 0x02, 0x01, 0xbb,
+				0x00, 0x07, 0x00, 0x08, '/', 'q', '{', '?', 'd', 'n', 's', '}',
+			},
+		},
+		{
+			"alpn only",
+			"dns.example addrs=2001:db8::53 alpn=dot",
+			2,
+			[]byte{
+				0x00, 0x90, 0x00, 0x2b,
+				0x00, 0x02,
+				0x00, 0x0d,
+				3, 'd', 'n', 's', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0,
+				0x00, 0x10,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+				0x00, 0x01, 0x00, 0x04, 3, 'd', 'o', 't',
+			},
+		},
+	}
+	for _, tt := range tests {
+		r, err := parseDNR(tt.resolver)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := wire(r.option(tt.priority)); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	ll "github.com/sirupsen/logrus"
+)
+
+// machineIDPath is where systemd keeps the host's unique 128bit machine id (used for DUID-UUID/EN)
+const machineIDPath = "/etc/machine-id"
+
+// duidAppID keys the hash of the machine id, the machine id itself is confidential and must not go out on the network
+var duidAppID = []byte{0x6f, 0xd3, 0x5a, 0x81, 0x19, 0xc2, 0x57, 0x46, 0x8d, 0x9d, 0x07, 0x95, 0x5b, 0xa3, 0x41, 0x1c}
+
+var duidTypes = map[string]dhcpv6.DuidType{
+	"llt":  dhcpv6.DUID_LLT,
+	"en":   dhcpv6.DUID_EN,
+	"ll":   dhcpv6.DUID_LL,
+	"uuid": dhcpv6.DUID_UUID,
+}
+
+func getDUIDTypes() []string {
+	var types []string
+	for k := range duidTypes {
+		types = append(types, k)
+	}
+	return types
+}
+
+// loadServerDUID returns the DUID the server identifies itself with.
+// an explicitly configured DUID always wins, otherwise the DUID stored in path is used.
+// if there is none yet a new one of the requested type is generated and written to path,
+// so the Server Identifier stays the same across replies and restarts
+func loadServerDUID(explicit, path, duidType string, enterprise uint32, ifName string) (*dhcpv6.Duid, error) {
+	if explicit != "" {
+		d, err := parseDUID(explicit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duid %s: %w", explicit, err)
+		}
+		return d, nil
+	}
+
+	t, ok := duidTypes[duidType]
+	if !ok {
+		return nil, fmt.Errorf("invalid duid type '%s'. Valid types are %v", duidType, getDUIDTypes())
+	}
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			d, err := parseDUID(string(b))
+			if err != nil {
+				return nil, fmt.Errorf("invalid duid in %s: %w", path, err)
+			}
+			if d.Type != t {
+				ll.Warnf("stored duid in %s is %s, not %s as configured. keeping the stored one", path, d.Type, t)
+			}
+			return d, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to read duid from %s: %w", path, err)
+		}
+	}
+
+	d, err := generateDUID(t, enterprise, ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		if err := storeDUID(path, d); err != nil {
+			// not fatal, we just won't be able to keep the same DUID across restarts
+			ll.Warnf("unable to persist duid to %s: %v", path, err)
+		} else {
+			ll.Infof("generated new %s and stored it in %s", d.Type, path)
+		}
+	}
+	return d, nil
+}
+
+// generateDUID builds a fresh DUID of the given type
+func generateDUID(t dhcpv6.DuidType, enterprise uint32, ifName string) (*dhcpv6.Duid, error) {
+	switch t {
+	case dhcpv6.DUID_LLT, dhcpv6.DUID_LL:
+		mac, err := getDUIDHardwareAddr(ifName)
+		if err != nil {
+			return nil, err
+		}
+		d := &dhcpv6.Duid{
+			Type:          t,
+			HwType:        iana.HWTypeEthernet,
+			LinkLayerAddr: mac,
+		}
+		if t == dhcpv6.DUID_LLT {
+			d.Time = dhcpv6.GetTime()
+		}
+		return d, nil
+	case dhcpv6.DUID_EN:
+		if enterprise == 0 {
+			return nil, fmt.Errorf("an enterprise number is required for %s", t)
+		}
+		id, err := getAppSpecificID()
+		if err != nil {
+			ll.Warnf("unable to use machine-id for %s, using random identifier: %v", t, err)
+			id = make([]byte, 16)
+			if _, err := rand.Read(id); err != nil {
+				return nil, fmt.Errorf("unable to generate identifier: %w", err)
+			}
+		}
+		return &dhcpv6.Duid{
+			Type:                 t,
+			EnterpriseNumber:     enterprise,
+			EnterpriseIdentifier: id,
+		}, nil
+	case dhcpv6.DUID_UUID:
+		id, err := getAppSpecificID()
+		if err != nil {
+			return nil, fmt.Errorf("unable to build %s: %w", t, err)
+		}
+		return &dhcpv6.Duid{
+			Type: t,
+			Uuid: id,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported duid type %s", t)
+}
+
+// getDUIDHardwareAddr returns the MAC of ifName or, if empty, of the first interface having an ethernet address
+func getDUIDHardwareAddr(ifName string) (net.HardwareAddr, error) {
+	if ifName != "" {
+		ifi, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get interface %s: %w", ifName, err)
+		}
+		if len(ifi.HardwareAddr) != 6 {
+			return nil, fmt.Errorf("interface %s has no ethernet address", ifName)
+		}
+		return ifi.HardwareAddr, nil
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list interfaces: %w", err)
+	}
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagLoopback == 0 && len(ifi.HardwareAddr) == 6 {
+			return ifi.HardwareAddr, nil
+		}
+	}
+	return nil, fmt.Errorf("no interface with an ethernet address found")
+}
+
+// getMachineID reads the 128bit machine id as handed out by systemd
+func getMachineID() ([]byte, error) {
+	b, err := os.ReadFile(machineIDPath)
+	if err != nil {
+		return nil, err
+	}
+	id, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid machine-id: %w", err)
+	}
+	if len(id) != 16 {
+		return nil, fmt.Errorf("invalid machine-id length %d", len(id))
+	}
+	return id, nil
+}
+
+// getAppSpecificID derives a stable 128bit id from the machine id like systemd's sd_id128_get_machine_app_specific:
+// HMAC-SHA256 keyed with the machine id over duidAppID, cut to 16 bytes and marked as UUID v4
+func getAppSpecificID() ([]byte, error) {
+	mid, err := getMachineID()
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, mid)
+	h.Write(duidAppID)
+	id := h.Sum(nil)[:16]
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// parseDUID parses a hex encoded DUID, bytes may be separated by ':' or '-'
+func parseDUID(s string) (*dhcpv6.Duid, error) {
+	s = strings.NewReplacer(":", "", "-", "").Replace(strings.TrimSpace(s))
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return dhcpv6.DuidFromBytes(b)
+}
+
+// formatDUID returns the DUID as colon separated hex string
+func formatDUID(d *dhcpv6.Duid) string {
+	b := d.ToBytes()
+	s := make([]string, len(b))
+	for i := range b {
+		s[i] = fmt.Sprintf("%02x", b[i])
+	}
+	return strings.Join(s, ":")
+}
+
+func storeDUID(path string, d *dhcpv6.Duid) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(formatDUID(d)+"\n"), 0644)
+}
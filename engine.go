@@ -4,15 +4,17 @@ import (
 	"fmt"
 	"regexp"
 	"sync"
+	"time"
 
 	ll "github.com/sirupsen/logrus"
 )
 
 // Engine is the main object collecting all running taps
 type Engine struct {
-	tap   map[int]*Listener
-	lock  sync.RWMutex
-	Flags *ListenerOptions
+	tap     map[int]*Listener
+	pending map[int]*time.Timer
+	lock    sync.RWMutex
+	Flags   *ListenerOptions
 }
 
 // NewEngine just setups up a empty new engine
@@ -25,10 +27,14 @@ func NewEngine(regex string) (*Engine, error) {
 	ll.Infof("Handling Interfaces matching '%s'", r.String())
 
 	return &Engine{
-		tap:  make(map[int]*Listener),
-		lock: sync.RWMutex{},
+		tap:     make(map[int]*Listener),
+		pending: make(map[int]*time.Timer),
+		lock:    sync.RWMutex{},
 		Flags: &ListenerOptions{
-			regex: r,
+			regex:        r,
+			quarantine:   NewQuarantine(),
+			reconfigures: NewReconfigures(),
+			config:       &Config{},
 		},
 	}, nil
 }
@@ -49,7 +55,7 @@ func (e *Engine) Add(ifIdx int) {
 	ll.WithFields(ll.Fields{"Interface": t.ifi.Name}).Tracef("adding %s", t.ifi.Name)
 
 	// need to lock/handle concurrency due to the cleanup inside the go routine
-	// eventually we could add some more logic to deal with on the fly route-changes by hooking into the routes channel
+	// on the fly route-changes are dealt with in RouteChanged
 	e.lock.Lock()
 	//assigning a copy to the map so I don't have to deal with concurrency while working with the tap itself
 	e.tap[ifIdx] = t
@@ -95,4 +101,35 @@ func (e *Engine) Close(ifIdx int) {
 	if err := tap.Close(); err != nil {
 		ll.WithFields(ll.Fields{"Interface": ifName}).Warnf("failed to close listener: %v", err)
 	}
+
+	// nobody left to reconfigure, retransmissions in flight stop as their client is gone
+	e.lock.Lock()
+	if t, ok := e.pending[ifIdx]; ok {
+		t.Stop()
+		delete(e.pending, ifIdx)
+	}
+	e.lock.Unlock()
+	e.Flags.reconfigures.ForgetInterface(ifName)
+}
+
+// RouteChanged asks the clients on ifIdx to renew once its routes did not change for delay,
+// so re-IPing a VM with several route updates only triggers one round of Reconfigure messages
+func (e *Engine) RouteChanged(ifIdx int, delay time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, ok := e.tap[ifIdx]; !ok {
+		return
+	}
+	if t, ok := e.pending[ifIdx]; ok {
+		t.Reset(delay)
+		return
+	}
+	e.pending[ifIdx] = time.AfterFunc(delay, func() {
+		e.lock.Lock()
+		delete(e.pending, ifIdx)
+		e.lock.Unlock()
+		if tap := e.Get(ifIdx); tap != nil {
+			tap.reconfigure()
+		}
+	})
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// what to do with a name proposed by the client in its FQDN option
+const (
+	// fqdnAccept hands the client's name back, partial names get our domain appended
+	fqdnAccept = "accept"
+	// fqdnOverride always hands out our name
+	fqdnOverride = "override"
+	// fqdnReject leaves the FQDN option out for clients proposing a name of their own
+	fqdnReject = "reject"
+)
+
+var fqdnPolicies = []string{fqdnAccept, fqdnOverride, fqdnReject}
+
+// RFC 4704 4.1 flags
+const (
+	fqdnFlagS = 1 << 0
+	fqdnFlagO = 1 << 1
+	fqdnFlagN = 1 << 2
+)
+
+func validFQDNPolicy(p string) error {
+	for _, v := range fqdnPolicies {
+		if v == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid fqdn policy '%s'. Valid policies are %v", p, fqdnPolicies)
+}
+
+// validDomainName checks name fits into RFC 1035 labels
+func validDomainName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return fmt.Errorf("invalid name length %d", len(name))
+	}
+	for _, l := range strings.Split(name, ".") {
+		if len(l) == 0 || len(l) > 63 {
+			return fmt.Errorf("invalid label '%s' in %s", l, name)
+		}
+	}
+	return nil
+}
+
+// fqdnLabels checks name is a valid domain name and returns it as labels, encoded fully qualified on the wire
+func fqdnLabels(name string) (*rfc1035label.Labels, error) {
+	if err := validDomainName(name); err != nil {
+		return nil, err
+	}
+	return &rfc1035label.Labels{Labels: []string{strings.TrimSuffix(name, ".")}}, nil
+}
+
+// fqdnOption answers the client's FQDN option (RFC 4704) according to policy, ours is the name we'd hand out.
+// we never update DNS ourselves, so N is always set and O whenever the client asked us to do the AAAA update.
+// it returns nil if the client gets no FQDN option at all
+func fqdnOption(msg *dhcpv6.Message, ours, domain, policy string) (*dhcpv6.OptFQDN, string, error) {
+	var flags uint8
+	var proposed string
+	if opt, ok := msg.GetOneOption(dhcpv6.OptionFQDN).(*dhcpv6.OptFQDN); ok {
+		flags = opt.Flags
+		if opt.DomainName != nil && len(opt.DomainName.Labels) > 0 {
+			proposed = strings.TrimSuffix(opt.DomainName.Labels[0], ".")
+		}
+	}
+
+	name := ours
+	if proposed != "" && !strings.EqualFold(proposed, ours) {
+		switch policy {
+		case fqdnAccept:
+			name = proposed
+			if !strings.Contains(proposed, ".") {
+				// a single label is a partial name, RFC 4704 4.2
+				name = proposed + "." + domain
+			}
+		case fqdnReject:
+			return nil, "", nil
+		}
+	}
+
+	labels, err := fqdnLabels(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reply := uint8(fqdnFlagN)
+	if flags&fqdnFlagS != 0 {
+		reply |= fqdnFlagO
+	}
+	return &dhcpv6.OptFQDN{Flags: reply, DomainName: labels}, name, nil
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"net"
-	"strings"
-	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/iana"
@@ -13,23 +11,6 @@ import (
 	"golang.org/x/net/ipv6"
 )
 
-func IsUsingUEFI(msg *dhcpv6.Message) bool {
-	if archTypes := msg.Options.ArchTypes(); archTypes != nil {
-		if archTypes.Contains(iana.EFI_BC) || archTypes.Contains(iana.EFI_X86_64) || archTypes.Contains(iana.EFI_X86_64_HTTP) {
-			return true
-		}
-	}
-	if opt := msg.GetOneOption(dhcpv6.OptionUserClass); opt != nil {
-		optuc := opt.(*dhcpv6.OptUserClass)
-		for _, uc := range optuc.UserClasses {
-			if strings.Contains(string(uc), "EFI") {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 // logClientInfo logs detailed information about a DHCPv6 client request
 // to help discriminate between different types of clients
 func logClientInfo(msg *dhcpv6.Message, peer *net.UDPAddr, srcMAC net.HardwareAddr) {
@@ -112,14 +93,10 @@ func (l *Listener) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.UD
 		return
 	}
 
-	// Log client identity information for discrimination / debugging
-	if ll.IsLevelEnabled(ll.DebugLevel) {
-		logClientInfo(msg, peer, srcMAC)
-	}
-
 	// Ignore clients with locally-administered (virtual) source MAC addresses.
 	// This filters out embedded processors like NVIDIA BlueField ECPF that
 	// use software-assigned MACs on the host-facing link.
+	// it is the MAC of the frame, whatever a Relay-Forward claims can't get around it
 	if *flagIgnoreVirtualMAC {
 		if isVirtualMAC(srcMAC) {
 			ll.Infof("handleMsg6: ignoring request from virtual MAC %s (peer %s) on %s",
@@ -128,92 +105,188 @@ func (l *Listener) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.UD
 		}
 	}
 
+	// relayed requests are answered for the link the client sits on, the relay's own address and MAC tell us nothing.
+	// only trusted relays get to pick another interface, anyone else could ask for the configuration of other guests
+	ifi := l.ifi
+	clientIP := peer.IP
+	clientMAC := srcMAC
+	var relay *dhcpv6.RelayMessage
+	if req.IsRelay() {
+		if !trustedRelays.Contains(peer.IP) {
+			drop(req, peer.IP, ifi, dropUntrustedRelay)
+			return
+		}
+		relay = req.(*dhcpv6.RelayMessage)
+		inner := innermostRelay(relay)
+		ifi = l.relayInterface(inner)
+		clientIP = inner.PeerAddr
+		// RFC 6939, only for logging and boot urls
+		_, clientMAC = inner.Options.ClientLinkLayerAddress()
+		ll.Debugf("handleMsg6: relayed by %s for %s on %s", peer.IP, clientIP, ifi.Name)
+	}
+
+	// Log client identity information for discrimination / debugging
+	if ll.IsLevelEnabled(ll.DebugLevel) {
+		logClientInfo(msg, peer, clientMAC)
+	}
+
 	// Create a suitable basic response packet
-	ll.Debugf("handleMsg6: received %s on %v", msg.Type(), l.ifi.Name)
+	ll.Debugf("handleMsg6: received %s on %v", msg.Type(), ifi.Name)
 	ll.Trace(req.Summary())
 
-	ifiRoutes, err := getHostRoutesIPv6(l.ifi.Index)
-	if err != nil {
-		ll.Errorf("failed to get routes for interface %v: %v", l.ifi.Name, err)
+	// RFC 8415 section 16, drop anything not meant for us so we can coexist with other servers on the segment
+	if reason := validateMessage(msg, l.Flags.serverID); reason != "" {
+		drop(msg, clientIP, ifi, reason)
 		return
 	}
-	ll.Debugf("handleMsg6: routes found for interface %v: %v", l.ifi.Name, ifiRoutes)
 
-	// seems like we have no host routes, not providing DHCP
-	if ifiRoutes == nil {
-		ll.Errorf("handleMsg6: we have no host routes for %s, not providing DHCP", l.ifi.Name)
+	set := l.Flags.config.Settings(ifi.Name, nil)
+
+	// stateless only interfaces just answer Information-Request, like a server not doing stateful service at all
+	if set.statelessOnly && msg.Type() != dhcpv6.MessageTypeInformationRequest {
+		drop(msg, clientIP, ifi, dropStatelessOnly)
 		return
 	}
 
-	// by default set the first IP in our return slice of routes
-	var pickedIP net.IP
-	for _, ip := range ifiRoutes {
-		if l.Flags.prefix.Contains(ip.IP) {
-			ll.Debugf("address %s picked", ip.IP.String())
-			pickedIP = ip.IP
-			break
+	// RFC 8415 18.4, clients may only unicast to us if we told them so with a Server Unicast option
+	if relay == nil && !oob.Dst.IsMulticast() && !*flagAcceptUnicast {
+		switch msg.Type() {
+		case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+			resp, err := newReply(msg,
+				dhcpv6.WithServerID(*l.Flags.serverID),
+				dhcpv6.WithOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusUseMulticast, StatusMessage: "use multicast"}),
+			)
+			if err != nil {
+				ll.Errorf("handleMsg6: failed building reply: %v", err)
+				return
+			}
+			n := stats.Inc("status." + iana.StatusUseMulticast.String())
+			ll.Infof("%s to %s on %s with status %s (%d so far)", resp.Type(), clientIP, ifi.Name, iana.StatusUseMulticast, n)
+			l.send(resp, relay, oob, peer)
+		default:
+			drop(msg, clientIP, ifi, dropUnicast)
 		}
-	}
-	if pickedIP == nil {
-		ll.Errorf("handleMsg6: no routes matched in the accepted prefix range on %s", l.ifi.Name)
 		return
 	}
 
-	ll.Debugf("handleMsg6: picked ip: %v", pickedIP)
+	ifiRoutes, ifiPrefixes, expiries, err := getRoutesIPv6(ifi.Index)
+	if err != nil {
+		ll.Errorf("failed to get routes for interface %v: %v", ifi.Name, err)
+		return
+	}
+	ll.Debugf("handleMsg6: routes found for interface %v: %v, prefixes: %v", ifi.Name, ifiRoutes, ifiPrefixes)
 
-	// mix DNS but mix em consistently so same IP gets the same order
-	dns := mixDNS(pickedIP)
+	// skip anything declined by the client before, addresses out of temporary-address pools are only ever handed out in IA_TA
+	addrs, tempAddrs := splitTemporary(
+		l.Flags.quarantine.Filter(ifi.Name, acceptedAddresses(ifiRoutes, l.Flags.prefix)),
+		temporaryPrefixes,
+	)
+	// lifetimes depend on interface and prefix, temporary routes may cut them short
+	lt := func(n *net.IPNet) lifetimes {
+		r := l.Flags.config.Settings(ifi.Name, n).lifetimes
+		if e, ok := expiries[n.String()]; ok && *flagLifetimeFromRoute {
+			r = r.capTo(e)
+		}
+		return r
+	}
 
-	fqdn := getHostname(l.ifi.Name, pickedIP)
+	if len(addrs) == 0 && msg.Type() != dhcpv6.MessageTypeInformationRequest {
+		// no host routes at all or none in the accepted prefix range, tell the client instead of leaving it retransmitting
+		ll.Warnf("handleMsg6: no host routes in the accepted prefix range on %s", ifi.Name)
+	}
 
 	// lets go compile the response
 	var mods []dhcpv6.Modifier
 
-	// Options to attach to all Replies
-	optIAAdress := dhcpv6.OptIAAddress{
-		IPv6Addr:          pickedIP,
-		PreferredLifetime: *flagLeaseTime,
-		ValidLifetime:     *flagLeaseTime * 2,
+	// a top level status (Confirm/Release/Decline) replaces all other configuration in the reply
+	var status *dhcpv6.OptStatusCode
+	clientAddrs := clientAddresses(msg)
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeConfirm:
+		confirmAddrs := append(clientTemporaryAddresses(msg), clientAddrs...)
+		// RFC 8415 18.3.3: without any addresses to confirm we must not reply at all
+		if len(confirmAddrs) == 0 {
+			ll.Debugf("handleMsg6: confirm without addresses on %s, not replying", ifi.Name)
+			return
+		}
+		if containsAllIPs(append(tempAddrs, addrs...), confirmAddrs) {
+			status = &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess, StatusMessage: "all addresses still on link"}
+		} else {
+			status = &dhcpv6.OptStatusCode{StatusCode: iana.StatusNotOnLink, StatusMessage: "address not on link"}
+		}
+	case dhcpv6.MessageTypeRelease:
+		// nothing to free up on our side but the reconfigure key
+		l.Flags.reconfigures.Forget(ifi.Name, msg.Options.ClientID())
+		status = &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess, StatusMessage: "released"}
+	case dhcpv6.MessageTypeDecline:
+		// the client found the address in use already, don't hand it out again for a while.
+		// only addresses we could have handed out on the interface, anything else would just pile up
+		for _, ip := range append(clientTemporaryAddresses(msg), clientAddrs...) {
+			if !containsAllIPs(append(tempAddrs, addrs...), []net.IP{ip}) {
+				ll.Infof("handleMsg6: %s declined %s on %s which isn't ours, ignoring", clientIP, ip, ifi.Name)
+				continue
+			}
+			l.Flags.quarantine.Add(ifi.Name, ip, *flagQuarantine)
+			n := stats.Inc("decline")
+			ll.WithFields(ll.Fields{"Interface": ifi.Name, "Address": ip.String()}).
+				Warnf("%s declined %s on %s, quarantined for %s (%d declines so far)", clientIP, ip, ifi.Name, *flagQuarantine, n)
+		}
+		status = &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess, StatusMessage: "declined"}
 	}
-	dhcpv6DUID := dhcpv6.Duid{
-		Type:          dhcpv6.DUID_LLT,
-		Time:          uint32(time.Now().Unix()),
-		LinkLayerAddr: l.ifi.HardwareAddr,
-		HwType:        iana.HWTypeEthernet,
+
+	// routed prefixes get delegated to clients asking for them
+	var delegated []*net.IPNet
+	if status == nil && msg.Options.GetOne(dhcpv6.OptionIAPD) != nil {
+		delegated = acceptedPrefixes(ifiPrefixes, l.Flags.prefix)
+		mods = append(mods, withOptions(iaPDOptions(msg, delegated, lt)...))
 	}
 
-	if msg.Options.GetOne(dhcpv6.OptionIANA) != nil {
-		clientIAID := msg.Options.OneIANA().IaId
-		mods = append(mods, dhcpv6.WithIAID(clientIAID))
+	// temporary addresses for clients asking for them
+	if status == nil && msg.Options.GetOne(dhcpv6.OptionIATA) != nil {
+		mods = append(mods, withOptions(iaTAOptions(msg, tempAddrs, lt)...))
 	}
 
-	msg.Options.Add(&optIAAdress)
+	// a client only asking for prefixes or temporary addresses doesn't get a non-temporary address,
+	// Information-Request is stateless and never gets any IA
+	wantsIANA := msg.Type() != dhcpv6.MessageTypeInformationRequest &&
+		(msg.Options.GetOne(dhcpv6.OptionIANA) != nil ||
+			(msg.Options.GetOne(dhcpv6.OptionIAPD) == nil && msg.Options.GetOne(dhcpv6.OptionIATA) == nil))
 
-	blobURL := ""
-	if IsUsingUEFI(msg) {
-		if *flagUefiUrl != "" {
-			blobURL = *flagUefiUrl
+	// pickedIP is the first address of the lowest IAID, the one hostname and DNS order are based on
+	var pickedIP net.IP
+	var handedOut []net.IP
+	if status == nil && wantsIANA {
+		var ianaOpts []dhcpv6.Option
+		ianaOpts, handedOut = iaNAOptions(msg, addrs, *flagAssignment, lt)
+		mods = append(mods, withOptions(ianaOpts...))
+		if len(handedOut) > 0 {
+			pickedIP = handedOut[0]
+			ll.Debugf("handleMsg6: picked ip: %v", pickedIP)
+			// the address handed out decides about the prefix scope for everything else
+			set = l.Flags.config.Settings(ifi.Name, hostRoute(pickedIP))
 		}
-	} else if *flagBiosUrl != "" {
-		blobURL = *flagBiosUrl
-	} else if *flagHTTPUrl != "" {
-		blobURL = *flagHTTPUrl
+	} else if status != nil {
+		mods = append(mods, dhcpv6.WithOption(status))
+	}
+	mods = append(mods, dhcpv6.WithServerID(*l.Flags.serverID))
+	if serverUnicast != nil && relay == nil {
+		mods = append(mods, dhcpv6.WithOption(optUnicast(serverUnicast)))
 	}
 
-	mods = append(mods, dhcpv6.WithIANA(optIAAdress))
-	mods = append(mods, dhcpv6.WithServerID(dhcpv6DUID))
-
-	var resp dhcpv6.DHCPv6
+	var resp *dhcpv6.Message
 
 	// Make sure we respond with the correct address
 	switch msg.Type() {
 	case dhcpv6.MessageTypeSolicit:
-		if msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
+		if msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil && set.rapidCommit {
 			resp, err = dhcpv6.NewReplyFromMessage(msg, mods...)
 			if err != nil {
 				ll.Errorf("handleMsg6: failed building reply from solicit: %v", err)
 				return
 			}
+			n := stats.Inc("rapid-commit")
+			ll.Infof("handleMsg6: rapid commit for %s on %s (%d so far)", clientIP, ifi.Name, n)
 		} else {
 			resp, err = dhcpv6.NewAdvertiseFromSolicit(msg, mods...)
 			if err != nil {
@@ -228,16 +301,74 @@ func (l *Listener) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.UD
 			ll.Errorf("handleMsg6: failed building reply: %v", err)
 			return
 		}
+	case dhcpv6.MessageTypeDecline:
+		resp, err = newReply(msg, mods...)
+		if err != nil {
+			ll.Errorf("handleMsg6: failed building reply from decline: %v", err)
+			return
+		}
 	default:
 		ll.Errorf("handleMsg6: message type %d not supported", msg.Type())
 		return
 	}
 
-	userClass := ""
-	if msg.Options.GetOne(dhcpv6.OptionUserClass) != nil {
-		userClass = msg.Options.GetOne(dhcpv6.OptionUserClass).String()
+	// clients pick the Advertise with the highest preference, RFC 8415 18.2.9
+	if resp.Type() == dhcpv6.MessageTypeAdvertise && set.preference > 0 {
+		resp.AddOption(optPreference(set.preference))
+	}
+
+	// let clients back off and refresh on our terms, RFC 8415 21.23-21.25
+	if set.solMaxRT > 0 {
+		resp.AddOption(optSolMaxRT(set.solMaxRT))
+	}
+	if msg.Type() == dhcpv6.MessageTypeInformationRequest {
+		if set.infMaxRT > 0 {
+			resp.AddOption(optInfMaxRT(set.infMaxRT))
+		}
+		if set.infoRefresh > 0 {
+			resp.AddOption(dhcpv6.OptInformationRefreshTime(set.infoRefresh))
+		}
+	}
+
+	for _, st := range statusCodes(resp) {
+		n := stats.Inc("status." + st.StatusCode.String())
+		ll.Infof("%s to %s on %s with status %s (%d so far)", resp.Type(), clientIP, ifi.Name, st.StatusCode, n)
+	}
+
+	// a top level status is all there is to say (Confirm/Release)
+	if status != nil {
+		ll.Trace(resp.Summary())
+		l.send(resp, relay, oob, peer)
+		return
+	}
+
+	// mix DNS but mix em consistently so same IP gets the same order, without an address go by the client's
+	mixIP := pickedIP
+	if pickedIP == nil {
+		mixIP = clientIP
+	}
+	dns := mixDNS(mixIP)
+
+	fqdn := getHostname(ifi.Name, mixIP)
+
+	// RFC 4704, a client sending an FQDN option gets one back. no address, no name.
+	// settled before anything else uses the name, i.e. the boot url
+	var fqdnOpt *dhcpv6.OptFQDN
+	if pickedIP != nil && (msg.GetOneOption(dhcpv6.OptionFQDN) != nil || msg.IsOptionRequested(dhcpv6.OptionFQDN)) {
+		opt, name, err := fqdnOption(msg, fqdn, *flagDomainname, set.fqdnPolicy)
+		if err != nil {
+			ll.Warnf("handleMsg6: not handing out fqdn to %s on %s: %v", clientIP, ifi.Name, err)
+		} else if opt != nil {
+			fqdnOpt = opt
+			fqdn = name
+		} else {
+			ll.Infof("handleMsg6: rejected fqdn proposed by %s on %s", clientIP, ifi.Name)
+			fqdn = ""
+		}
 	}
 
+	boot := bootTarget(msg, set.bootURLs)
+
 	archTypes := msg.Options.ArchTypes()
 	ll.Debugf("Found architecture %v", archTypes)
 
@@ -245,55 +376,151 @@ func (l *Listener) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.UD
 	for _, code := range msg.Options.RequestedOptions() {
 		switch code {
 		case dhcpv6.OptionBootfileURL:
-			if *flagiPXE != "" && strings.Contains(userClass, "iPXE") {
-				bootOpt := dhcpv6.OptBootFileURL(*flagiPXE)
-				resp.AddOption(bootOpt)
-			} else if blobURL != "" {
-				blobOpt := dhcpv6.OptBootFileURL(blobURL)
-				resp.AddOption(blobOpt)
+			u := bootURL(msg, boot, set.bootURLs)
+			if u == "" {
+				continue
+			}
+			u, err := renderBootURL(u, newBootVars(msg, ifi.Name, pickedIP, clientMAC, fqdn))
+			if err != nil {
+				ll.Warnf("handleMsg6: not handing out boot url to %s on %s: %v", clientIP, ifi.Name, err)
+				continue
 			}
-		case dhcpv6.OptionVendorClass:
-			dataString := []byte("HTTPClient")
-			dataSlice := [][]byte{}
-			dataSlice = append(dataSlice, dataString)
-			vendorOpts := dhcpv6.OptVendorClass{
-				EnterpriseNumber: 10,
-				Data:             dataSlice,
+			resp.AddOption(dhcpv6.OptBootFileURL(u))
+		case dhcpv6.OptionBootfileParam:
+			if p := set.bootParams[boot]; len(p) > 0 {
+				resp.AddOption(dhcpv6.OptBootFileParam(p...))
 			}
-			resp.AddOption(&vendorOpts)
+		case dhcpv6.OptionVendorClass, dhcpv6.OptionVendorOpts:
+			// both answered below, one option per matching enterprise number
+			continue
 		case dhcpv6.OptionFQDN:
-			resp.AddOption(&dhcpv6.OptFQDN{
-				Flags: 0,
-				DomainName: &rfc1035label.Labels{
-					Labels: []string{fqdn},
-				},
-			})
+			// answered below, clients don't have to ask for it to get it
+			continue
 		case dhcpv6.OptionDNSRecursiveNameServer:
 			resp.AddOption(dhcpv6.OptDNS(dns...))
+		case dhcpv6.OptionNTPServer:
+			if len(set.ntpServers) == 0 {
+				continue
+			}
+			resp.AddOption(ntpOption(mixNTP(mixIP, set.ntpServers)))
+		case optionV6DNR:
+			for _, o := range dnrOptions(mixIP, set.dnr) {
+				resp.AddOption(o)
+			}
+		case dhcpv6.OptionAFTRName:
+			if set.aftrName != "" {
+				resp.AddOption(optAFTRName(set.aftrName))
+			}
+		case dhcpv6.OptionS46ContMapE, dhcpv6.OptionS46ContMapT, dhcpv6.OptionS46ContLW:
+			// answered below, all at once
+			continue
 		case dhcpv6.OptionDomainSearchList:
-			searchDomain := &rfc1035label.Labels{
-				Labels: []string{*flagDomainname},
+			if len(set.searchDomains) == 0 {
+				continue
 			}
-			resp.AddOption(dhcpv6.OptDomainSearchList(searchDomain))
+			// every domain is encoded as its own label sequence
+			resp.AddOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: set.searchDomains}))
 
 		default:
-			ll.Infof("handleMsg6: no match for option code: %v", code)
+			if !set.hasOption(code) {
+				ll.Infof("handleMsg6: no match for option code: %v", code)
+			}
 			continue
 		}
 	}
 
-	ll.Infof(
-		"%s to %s on %s with %s, lease %gm, fqdn %s",
-		resp.Type(),
-		peer.IP,
-		l.ifi.Name,
-		pickedIP,
-		optIAAdress.PreferredLifetime.Minutes(),
-		fqdn,
-	)
+	for _, o := range s46Options(msg, set.s46, pickedIP) {
+		resp.AddOption(o)
+	}
+
+	for _, o := range vendorOptions(msg, set.vendors) {
+		resp.AddOption(o)
+	}
+
+	// options from the config replace ours of the same code
+	for _, o := range set.options {
+		if o.Always || msg.IsOptionRequested(o.option.Code()) {
+			resp.UpdateOption(o.option)
+		}
+	}
+
+	// clients accepting Reconfigure get the key to authenticate our Reconfigure messages with.
+	// relayed clients are left out as we'd have to remember the relay path to reach them
+	if *flagReconfigure && relay == nil && pickedIP != nil && resp.Type() == dhcpv6.MessageTypeReply &&
+		msg.GetOneOption(dhcpv6.OptionReconfAccept) != nil {
+		key, err := l.Flags.reconfigures.Bind(ifi.Name, msg.Options.ClientID(), clientIP, lt(hostRoute(pickedIP)).valid)
+		if err != nil {
+			ll.Warnf("handleMsg6: %v", err)
+		} else {
+			resp.AddOption(optReconfAccept())
+			resp.AddOption(l.Flags.reconfigures.KeyOption(key))
+		}
+	}
+
+	if fqdnOpt != nil {
+		resp.AddOption(fqdnOpt)
+	}
+
+	if pickedIP != nil {
+		ll.Infof(
+			"%s to %s on %s with %s, lease %gm, fqdn %s",
+			resp.Type(),
+			clientIP,
+			ifi.Name,
+			handedOut,
+			lt(hostRoute(pickedIP)).preferred.Minutes(),
+			fqdn,
+		)
+	}
+	if msg.Type() == dhcpv6.MessageTypeInformationRequest {
+		ll.Infof("%s to %s on %s, stateless", resp.Type(), clientIP, ifi.Name)
+	}
+	if len(delegated) > 0 {
+		ll.Infof("%s to %s on %s delegating %v", resp.Type(), clientIP, ifi.Name, delegated)
+	}
 	ll.Trace(resp.Summary())
 
-	if _, err := l.c.WriteTo(resp.ToBytes(), &ipv6.ControlMessage{IfIndex: oob.IfIndex}, peer); err != nil {
+	l.send(resp, relay, oob, peer)
+}
+
+// newReply builds a Reply to msg carrying just the Client Identifier and whatever the modifiers add.
+// unlike dhcpv6.NewReplyFromMessage it answers any message type, i.e. a Decline
+func newReply(msg *dhcpv6.Message, modifiers ...dhcpv6.Modifier) (*dhcpv6.Message, error) {
+	cid := msg.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil, fmt.Errorf("client id cannot be nil when building reply")
+	}
+	rep := &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: msg.TransactionID,
+	}
+	rep.AddOption(cid)
+	for _, mod := range modifiers {
+		mod(rep)
+	}
+	return rep, nil
+}
+
+// send writes resp back to peer on the interface the request was received on.
+// relayed requests get resp wrapped into a Relay-Reply sent to the relay on the server port
+func (l *Listener) send(resp *dhcpv6.Message, relay *dhcpv6.RelayMessage, oob *ipv6.ControlMessage, peer *net.UDPAddr) {
+	var out dhcpv6.DHCPv6 = resp
+	if relay != nil {
+		repl, err := newRelayReply(relay, resp)
+		if err != nil {
+			ll.Errorf("handleMsg6: failed building relay-reply: %v", err)
+			return
+		}
+		ll.Trace(repl.Summary())
+		out = repl
+		peer = &net.UDPAddr{IP: peer.IP, Port: dhcpv6.DefaultServerPort, Zone: peer.Zone}
+	}
+	cm := &ipv6.ControlMessage{IfIndex: oob.IfIndex}
+	if !oob.Dst.IsMulticast() {
+		// answer from the address we got unicasted to
+		cm.Src = oob.Dst
+	}
+	if _, err := l.c.WriteTo(out.ToBytes(), cm, peer); err != nil {
 		ll.Warnf("handleMsg6: write to connection %v failed: %v", peer, err)
 	}
 }
@@ -5,9 +5,14 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	ll "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 func getHostname(ifName string, ip net.IP) string {
@@ -59,8 +64,7 @@ func getHostnameOverride(ifName string) (string, string, error) {
 // mixDNS sorts dns servers in a sudo-random way (the provided IP should always get back the same sequence of DNS)
 func mixDNS(ip net.IP) []net.IP {
 	l := len(dns)
-	// just mod over last octet of IP as it provides the highest diversity without causing much complexity
-	m := int(ip[len(ip)-1]) % l
+	m := mixOffset(ip, l)
 	var mix []net.IP
 
 	for i := 0; i < l; i++ {
@@ -74,6 +78,25 @@ func mixDNS(ip net.IP) []net.IP {
 	return mix
 }
 
+// mixNTP sorts ntp servers the same way mixDNS does for dns
+func mixNTP(ip net.IP, servers []string) []string {
+	l := len(servers)
+	if l == 0 {
+		return nil
+	}
+	m := mixOffset(ip, l)
+	mix := append(append([]string{}, servers[m:]...), servers[:m]...)
+
+	ll.Tracef("NTP mixed to %s", mix)
+	return mix
+}
+
+// mixOffset returns where the sequence for ip starts in a list of l servers
+func mixOffset(ip net.IP, l int) int {
+	// just mod over last octet of IP as it provides the highest diversity without causing much complexity
+	return int(ip[len(ip)-1]) % l
+}
+
 type listIP []net.IP
 
 func (ip *listIP) String() string {
@@ -93,6 +116,87 @@ func (ip *listIP) Set(value string) error {
 	return fmt.Errorf("invalid ip: %v", value)
 }
 
+// Contains checks if ip is in the list
+func (ip listIP) Contains(i net.IP) bool {
+	for _, e := range ip {
+		if e.Equal(i) {
+			return true
+		}
+	}
+	return false
+}
+
+type listIPNet []*net.IPNet
+
+func (n *listIPNet) String() string {
+	var s string
+	for _, i := range *n {
+		s = s + " " + i.String()
+	}
+	return s
+}
+
+func (n *listIPNet) Set(value string) error {
+	_, p, err := net.ParseCIDR(value)
+	if err != nil {
+		return fmt.Errorf("invalid prefix: %v", value)
+	}
+	*n = append(*n, p)
+	return nil
+}
+
+// Contains checks if ip is part of any of the prefixes
+func (n listIPNet) Contains(ip net.IP) bool {
+	for _, p := range n {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// listNTP is a list of NTP servers, addresses or names
+type listNTP []string
+
+func (n *listNTP) String() string {
+	return strings.Join(*n, " ")
+}
+
+func (n *listNTP) Set(value string) error {
+	if err := validNTPServer(value); err != nil {
+		return fmt.Errorf("invalid ntp server %s: %v", value, err)
+	}
+	*n = append(*n, value)
+	return nil
+}
+
+// listDomain is a list of domain names, i.e. search domains
+type listDomain []string
+
+func (d *listDomain) String() string {
+	return strings.Join(*d, " ")
+}
+
+func (d *listDomain) Set(value string) error {
+	if err := validDomainName(value); err != nil {
+		return fmt.Errorf("invalid domain %s: %v", value, err)
+	}
+	*d = append(*d, strings.TrimSuffix(value, "."))
+	return nil
+}
+
+// listString is a list of arbitrary strings, i.e. boot file parameters
+type listString []string
+
+func (l *listString) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listString) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func getLogLevels() []string {
 	var levels []string
 	for k := range logLevels {
@@ -101,33 +205,150 @@ func getLogLevels() []string {
 	return levels
 }
 
-func getHostRoutesIPv6(ifIndex int) ([]*net.IPNet, error) {
-	nlh, err := netlink.NewHandle()
+// getRoutesIPv6 returns the /128 host routes and the routed prefixes (shorter than /128) pointing at ifIndex of the main table,
+// along with the time left for every one of them that expires, keyed by destination.
+// link-local, multicast and kernel generated (connected) routes are never considered prefixes.
+// the netlink library doesn't expose the route cacheinfo, so we dump the routes ourselves
+func getRoutesIPv6(ifIndex int) ([]*net.IPNet, []*net.IPNet, map[string]time.Duration, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETROUTE, unix.NLM_F_DUMP)
+	rtm := nl.NewRtMsg()
+	rtm.Family = unix.AF_INET6
+	req.AddData(rtm)
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWROUTE)
 	if err != nil {
-		return nil, fmt.Errorf("unable to hook into netlink: %v", err)
+		return nil, nil, nil, fmt.Errorf("unable to dump routes: %w", err)
 	}
-	defer nlh.Delete()
 
-	link, err := netlink.LinkByIndex(ifIndex)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get link info: %v", err)
+	var hosts, prefixes []*net.IPNet
+	expiries := make(map[string]time.Duration)
+	for _, m := range msgs {
+		msg := nl.DeserializeRtMsg(m)
+		if msg.Flags&unix.RTM_F_CLONED != 0 || msg.Table != unix.RT_TABLE_MAIN {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to parse route: %w", err)
+		}
+		var oif int
+		var dst net.IP
+		var expires time.Duration
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unix.RTA_OIF:
+				oif = int(nl.NativeEndian().Uint32(a.Value))
+			case unix.RTA_DST:
+				dst = net.IP(a.Value)
+			case unix.RTA_CACHEINFO:
+				// struct rta_cacheinfo, rta_expires is the third field
+				if len(a.Value) >= 12 {
+					expires = time.Duration(int32(nl.NativeEndian().Uint32(a.Value[8:12]))) * time.Second / userHZ
+				}
+			}
+		}
+		if oif != ifIndex || dst == nil {
+			continue
+		}
+
+		n := &net.IPNet{IP: dst, Mask: net.CIDRMask(int(msg.Dst_len), 128)}
+		if expires > 0 {
+			expiries[n.String()] = expires
+		}
+		switch {
+		case msg.Dst_len == 128:
+			hosts = append(hosts, n)
+		case msg.Dst_len == 0 || msg.Protocol == unix.RTPROT_KERNEL || dst.IsLinkLocalUnicast() || dst.IsMulticast():
+			continue
+		default:
+			prefixes = append(prefixes, n)
+		}
 	}
+	return hosts, prefixes, expiries, nil
+}
 
-	ro, err := nlh.RouteList(link, netlink.FAMILY_V6)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get routes: %v", err)
+// acceptedAddresses returns the addresses of all host routes within prefix
+func acceptedAddresses(routes []*net.IPNet, prefix *net.IPNet) []net.IP {
+	var ips []net.IP
+	for _, r := range routes {
+		if prefix.Contains(r.IP) {
+			ips = append(ips, r.IP)
+		}
 	}
+	return ips
+}
+
+// acceptedPrefixes returns all routed prefixes fully within prefix
+func acceptedPrefixes(routes []*net.IPNet, prefix *net.IPNet) []*net.IPNet {
 	var r []*net.IPNet
-	for _, d := range ro {
-		if d.Dst == nil {
-			continue
+	pl, _ := prefix.Mask.Size()
+	for _, p := range routes {
+		if l, _ := p.Mask.Size(); prefix.Contains(p.IP) && l >= pl {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+// splitTemporary splits ips into addresses for IA_NA and addresses within the temporary-address pools for IA_TA
+func splitTemporary(ips []net.IP, pools listIPNet) ([]net.IP, []net.IP) {
+	var addrs, temp []net.IP
+	for _, ip := range ips {
+		if pools.Contains(ip) {
+			temp = append(temp, ip)
+		} else {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs, temp
+}
+
+// clientAddresses returns all addresses a client included in its IA_NAs (i.e. on Renew/Rebind/Confirm)
+func clientAddresses(msg *dhcpv6.Message) []net.IP {
+	var ips []net.IP
+	for _, ia := range msg.Options.IANA() {
+		for _, a := range ia.Options.Addresses() {
+			ips = append(ips, a.IPv6Addr)
+		}
+	}
+	return ips
+}
+
+// clientTemporaryAddresses returns all addresses a client included in its IA_TAs
+func clientTemporaryAddresses(msg *dhcpv6.Message) []net.IP {
+	var ips []net.IP
+	for _, ia := range msg.Options.IATA() {
+		for _, a := range ia.Options.Addresses() {
+			ips = append(ips, a.IPv6Addr)
+		}
+	}
+	return ips
+}
+
+// containsAllIPs checks if every IP of want is part of have
+func containsAllIPs(have []net.IP, want []net.IP) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h.Equal(w) {
+				found = true
+				break
+			}
 		}
-		m, l := d.Dst.Mask.Size()
-		if m == 128 && l == 128 {
-			r = append(r, d.Dst)
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// withOptions adds all opts to a DHCPv6 message, unlike dhcpv6.WithOption it keeps multiple options of the same code (i.e. IAs)
+func withOptions(opts ...dhcpv6.Option) dhcpv6.Modifier {
+	return func(d dhcpv6.DHCPv6) {
+		for _, o := range opts {
+			d.AddOption(o)
 		}
 	}
-	return r, nil
 }
 
 // linkReady will return true when its ok to bind the ndp listener to it.
@@ -156,3 +377,71 @@ func isVirtualMAC(mac net.HardwareAddr) bool {
 	}
 	return mac[0]&0x02 != 0
 }
+
+// localAddressesTTL is how long the cached local addresses are used before dumping them again
+const localAddressesTTL = 10 * time.Second
+
+// localAddresses caches our addresses, frames to unicast addresses must not cost a netlink dump each - thread safe
+type localAddresses struct {
+	addrs   map[string]bool
+	updated time.Time
+	lock    sync.Mutex
+}
+
+var localAddrs = &localAddresses{}
+
+// localAddressKey scopes link-local addresses to their interface
+func localAddressKey(ifIndex int, ip net.IP) string {
+	if ip.IsLinkLocalUnicast() {
+		return fmt.Sprintf("%d%%%s", ifIndex, ip)
+	}
+	return ip.String()
+}
+
+// contains checks ip against the cache, refreshing it once it is older than localAddressesTTL
+func (a *localAddresses) contains(ifIndex int, ip net.IP) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if time.Since(a.updated) > localAddressesTTL {
+		// a failing dump is not retried before the TTL passed either
+		a.updated = time.Now()
+		if addrs, err := dumpLocalAddresses(); err != nil {
+			ll.Warnf("unable to get local addresses: %v", err)
+		} else {
+			a.addrs = addrs
+		}
+	}
+	return a.addrs[localAddressKey(ifIndex, ip)]
+}
+
+// dumpLocalAddresses gets the IPv6 addresses of all interfaces with one netlink dump
+func dumpLocalAddresses() (map[string]bool, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETADDR, unix.NLM_F_DUMP)
+	req.AddData(nl.NewIfAddrmsg(unix.AF_INET6))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWADDR)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dump addresses: %w", err)
+	}
+
+	addrs := make(map[string]bool)
+	for _, m := range msgs {
+		msg := nl.DeserializeIfAddrmsg(m)
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse address: %w", err)
+		}
+		for _, a := range attrs {
+			if a.Attr.Type == unix.IFA_ADDRESS {
+				addrs[localAddressKey(int(msg.Index), net.IP(a.Value))] = true
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// isLocalAddress checks if ip is one of our addresses, link-local ones have to be on ifi.
+// addresses are cached for localAddressesTTL
+func isLocalAddress(ifi *net.Interface, ip net.IP) bool {
+	return localAddrs.contains(ifi.Index, ip)
+}
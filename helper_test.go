@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// wire returns opts with their option headers, the way they go out
+func wire(opts ...dhcpv6.Option) []byte {
+	return dhcpv6.Options(opts).ToBytes()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// userHZ is the unit of clock_t the kernel reports route expiry in
+const userHZ = 100
+
+// lifetimes are handed out with every address and prefix, T1 and T2 go into the IA
+type lifetimes struct {
+	preferred time.Duration
+	valid     time.Duration
+	t1        time.Duration
+	t2        time.Duration
+}
+
+// lifetimesFunc returns the lifetimes for a host route or delegated prefix
+type lifetimesFunc func(n *net.IPNet) lifetimes
+
+// derive fills in anything not set, valid defaults to twice preferred and T1/T2 to .5 and .8 of preferred as per RFC 8415 21.4
+func (lt lifetimes) derive() lifetimes {
+	if lt.preferred == 0 {
+		lt.preferred = *flagLeaseTime
+	}
+	if lt.valid == 0 {
+		lt.valid = lt.preferred * 2
+	}
+	if lt.t1 == 0 {
+		lt.t1 = lt.preferred / 2
+	}
+	if lt.t2 == 0 {
+		lt.t2 = lt.preferred * 4 / 5
+	}
+	return lt
+}
+
+// validate makes sure clients don't discard what we hand out, i.e. preferred > valid or T1 > T2
+func (lt lifetimes) validate() error {
+	if lt.preferred <= 0 {
+		return fmt.Errorf("preferred lifetime %s has to be positive", lt.preferred)
+	}
+	if lt.preferred > lt.valid {
+		return fmt.Errorf("preferred lifetime %s exceeds valid lifetime %s", lt.preferred, lt.valid)
+	}
+	if lt.t1 > lt.t2 {
+		return fmt.Errorf("t1 %s exceeds t2 %s", lt.t1, lt.t2)
+	}
+	if lt.t2 > lt.valid {
+		return fmt.Errorf("t2 %s exceeds valid lifetime %s", lt.t2, lt.valid)
+	}
+	return nil
+}
+
+// capTo shortens the lifetimes so nothing outlives d, i.e. the expiry of the route
+func (lt lifetimes) capTo(d time.Duration) lifetimes {
+	for _, t := range []*time.Duration{&lt.valid, &lt.preferred, &lt.t2, &lt.t1} {
+		if *t > d {
+			*t = d
+		}
+	}
+	return lt
+}
+
+// iaTimers returns T1 and T2 of an IA holding addresses or prefixes with lt, the shortest ones win.
+// an IA without any leaves them to the client
+func iaTimers(lt []lifetimes) (t1, t2 time.Duration) {
+	for i, l := range lt {
+		if i == 0 || l.t1 < t1 {
+			t1 = l.t1
+		}
+		if i == 0 || l.t2 < t2 {
+			t2 = l.t2
+		}
+	}
+	return t1, t2
+}
+
+// Duration is a time.Duration read from json as string, i.e. "30m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration has to be a string like \"30m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// hostRoute returns the /128 route of ip
+func hostRoute(ip net.IP) *net.IPNet {
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
@@ -34,8 +34,12 @@ type Listener struct {
 }
 
 type ListenerOptions struct {
-	prefix *net.IPNet
-	regex  *regexp.Regexp
+	prefix       *net.IPNet
+	regex        *regexp.Regexp
+	serverID     *dhcpv6.Duid
+	quarantine   *Quarantine
+	reconfigures *Reconfigures
+	config       *Config
 }
 
 func (lo *ListenerOptions) SetPrefix(p *net.IPNet) {
@@ -43,6 +47,18 @@ func (lo *ListenerOptions) SetPrefix(p *net.IPNet) {
 	lo.prefix = p
 }
 
+// SetConfig sets the configuration overriding the flags per interface
+func (lo *ListenerOptions) SetConfig(c *Config) {
+	ll.Infof("Using %d interface scopes from config", len(c.Interfaces))
+	lo.config = c
+}
+
+// SetServerID sets the DUID handed out in the Server Identifier option of every reply
+func (lo *ListenerOptions) SetServerID(d *dhcpv6.Duid) {
+	ll.Infof("Using server %s (%s)", d, formatDUID(d))
+	lo.serverID = d
+}
+
 // NewListener creates a new instance of DHCP listener.
 // It opens two sockets on the interface:
 //   - a UDP socket joined to the DHCPv6 all-servers multicast group, used only
@@ -154,16 +170,22 @@ func (l *Listener) Listen() error {
 			return err
 		}
 
-		srcMAC, dhcpPayload, peer, err := parseEthernetFrame(buf[:n])
+		srcMAC, dhcpPayload, peer, dst, err := parseEthernetFrame(buf[:n])
 		if err != nil {
 			ll.Debugf("Listen %s: skipping frame: %v", l.ifi.Name, err)
 			continue
 		}
 
+		// unicast frames are only for us if sent to one of our own addresses, whether we answer them is up to HandleMsg6
+		if !dst.IsMulticast() && !isLocalAddress(l.ifi, dst) {
+			ll.Debugf("Listen %s: skipping frame to %s: not one of our addresses", l.ifi.Name, dst)
+			continue
+		}
+
 		// Link-local addresses require the interface zone for the reply.
 		peer.Zone = l.ifi.Name
 
-		oob := &ipv6.ControlMessage{IfIndex: l.ifi.Index}
+		oob := &ipv6.ControlMessage{IfIndex: l.ifi.Index, Dst: dst}
 
 		// Copy the payload out of the shared buffer before handing it to the goroutine.
 		pkt := make([]byte, len(dhcpPayload))
@@ -174,16 +196,17 @@ func (l *Listener) Listen() error {
 }
 
 // parseEthernetFrame validates and parses an Ethernet frame containing an IPv6
-// UDP DHCPv6 datagram destined for the DHCPv6 all-servers multicast address.
+// UDP DHCPv6 datagram destined for the DHCPv6 all-servers multicast address or
+// a unicast address.
 // It returns the Ethernet source MAC, the DHCPv6 payload slice (sub-slice of
-// frame), and the UDP source address.
-func parseEthernetFrame(frame []byte) (srcMAC net.HardwareAddr, dhcpPayload []byte, peer *net.UDPAddr, err error) {
+// frame), the UDP source address and the IPv6 destination address.
+func parseEthernetFrame(frame []byte) (srcMAC net.HardwareAddr, dhcpPayload []byte, peer *net.UDPAddr, dst net.IP, err error) {
 	if len(frame) < etherHeaderLen {
-		return nil, nil, nil, fmt.Errorf("frame too short (%d bytes)", len(frame))
+		return nil, nil, nil, nil, fmt.Errorf("frame too short (%d bytes)", len(frame))
 	}
 
 	if binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv6 {
-		return nil, nil, nil, fmt.Errorf("not IPv6 (ethertype 0x%04x)", binary.BigEndian.Uint16(frame[12:14]))
+		return nil, nil, nil, nil, fmt.Errorf("not IPv6 (ethertype 0x%04x)", binary.BigEndian.Uint16(frame[12:14]))
 	}
 
 	mac := make(net.HardwareAddr, 6)
@@ -191,15 +214,17 @@ func parseEthernetFrame(frame []byte) (srcMAC net.HardwareAddr, dhcpPayload []by
 
 	ipv6Frame := frame[etherHeaderLen:]
 	if len(ipv6Frame) < ipv6HeaderLen {
-		return nil, nil, nil, fmt.Errorf("IPv6 header truncated")
+		return nil, nil, nil, nil, fmt.Errorf("IPv6 header truncated")
 	}
 
 	if ipv6Frame[6] != 17 { // next header: UDP
-		return nil, nil, nil, fmt.Errorf("not UDP (next header %d)", ipv6Frame[6])
+		return nil, nil, nil, nil, fmt.Errorf("not UDP (next header %d)", ipv6Frame[6])
 	}
 
-	if !net.IP(ipv6Frame[24:40]).Equal(dhcpv6.AllDHCPRelayAgentsAndServers) {
-		return nil, nil, nil, fmt.Errorf("not DHCPv6 multicast dst")
+	dstIP := make(net.IP, 16)
+	copy(dstIP, ipv6Frame[24:40])
+	if dstIP.IsMulticast() && !dstIP.Equal(dhcpv6.AllDHCPRelayAgentsAndServers) {
+		return nil, nil, nil, nil, fmt.Errorf("not DHCPv6 multicast dst")
 	}
 
 	srcIP := make(net.IP, 16)
@@ -207,55 +232,71 @@ func parseEthernetFrame(frame []byte) (srcMAC net.HardwareAddr, dhcpPayload []by
 
 	udpFrame := ipv6Frame[ipv6HeaderLen:]
 	if len(udpFrame) < udpHeaderLen {
-		return nil, nil, nil, fmt.Errorf("UDP header truncated")
+		return nil, nil, nil, nil, fmt.Errorf("UDP header truncated")
 	}
 
 	if binary.BigEndian.Uint16(udpFrame[2:4]) != uint16(dhcpv6.DefaultServerPort) {
-		return nil, nil, nil, fmt.Errorf("not DHCPv6 server port (%d)", binary.BigEndian.Uint16(udpFrame[2:4]))
+		return nil, nil, nil, nil, fmt.Errorf("not DHCPv6 server port (%d)", binary.BigEndian.Uint16(udpFrame[2:4]))
 	}
 
 	dhcp := udpFrame[udpHeaderLen:]
 	if len(dhcp) == 0 {
-		return nil, nil, nil, fmt.Errorf("empty DHCPv6 payload")
+		return nil, nil, nil, nil, fmt.Errorf("empty DHCPv6 payload")
 	}
 
 	return mac, dhcp, &net.UDPAddr{
 		IP:   srcIP,
 		Port: int(binary.BigEndian.Uint16(udpFrame[0:2])),
-	}, nil
+	}, dstIP, nil
 }
 
 // dhcpv6FilterInstructions returns the classic BPF instructions that select
-// only IPv6/UDP frames destined for DHCPv6 server port 547.
+// only IPv6/UDP frames destined for DHCPv6 server port 547, sent either to the
+// All_DHCP_Relay_Agents_and_Servers group or to any unicast address.
 //
 // Frame layout assumed (no 802.1Q VLAN tag):
 //
 //	[12:14] EtherType (must be 0x86DD for IPv6)
 //	[20]    IPv6 next-header (byte 6 of the 40-byte IPv6 header)
+//	[38:54] IPv6 destination address (14 + 24)
 //	[56:58] UDP destination port (14 + 40 + 2)
 func dhcpv6FilterInstructions() []bpf.Instruction {
 	return []bpf.Instruction{
 		// 0: load EtherType halfword
 		bpf.LoadAbsolute{Off: 12, Size: 2},
 		// 1: drop if not IPv6 (0x86DD)
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x86DD, SkipTrue: 0, SkipFalse: 5},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x86DD, SkipTrue: 0, SkipFalse: 15},
 		// 2: load IPv6 next-header byte
 		bpf.LoadAbsolute{Off: 20, Size: 1},
 		// 3: drop if not UDP (17)
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipTrue: 0, SkipFalse: 3},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipTrue: 0, SkipFalse: 13},
 		// 4: load UDP destination port halfword
 		bpf.LoadAbsolute{Off: 56, Size: 2},
-		// 5: accept if dst port == 547, else drop
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(dhcpv6.DefaultServerPort), SkipTrue: 0, SkipFalse: 1},
-		// 6: accept — return full packet length
+		// 5: drop if dst port != 547
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(dhcpv6.DefaultServerPort), SkipTrue: 0, SkipFalse: 11},
+		// 6: load first byte of the IPv6 destination
+		bpf.LoadAbsolute{Off: 38, Size: 1},
+		// 7: accept unicast, which addresses are ours is checked in userspace
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xff, SkipTrue: 0, SkipFalse: 8},
+		// 8-15: multicast must be ff02::1:2
+		bpf.LoadAbsolute{Off: 38, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xff020000, SkipTrue: 0, SkipFalse: 7},
+		bpf.LoadAbsolute{Off: 42, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 0, SkipFalse: 5},
+		bpf.LoadAbsolute{Off: 46, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 0, SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 50, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x00010002, SkipTrue: 0, SkipFalse: 1},
+		// 16: accept — return full packet length
 		bpf.RetConstant{Val: 0xFFFF},
-		// 7: drop — return 0
+		// 17: drop — return 0
 		bpf.RetConstant{Val: 0},
 	}
 }
 
 // attachDHCPv6Filter installs a classic BPF filter on fd that passes only
-// IPv6/UDP frames destined for DHCPv6 server port 547 (0x0223).
+// IPv6/UDP frames destined for DHCPv6 server port 547 (0x0223), either multicast
+// to ff02::1:2 or unicast.
 func attachDHCPv6Filter(fd int) error {
 	insts, err := bpf.Assemble(dhcpv6FilterInstructions())
 	if err != nil {
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"golang.org/x/net/bpf"
+)
+
+// testFrame builds an Ethernet/IPv6/UDP frame from fe80::1 port 546 to dst
+func testFrame(etherType uint16, nextHeader byte, dst string, dstPort uint16, payload []byte) []byte {
+	frame := make([]byte, etherHeaderLen+ipv6HeaderLen+udpHeaderLen, etherHeaderLen+ipv6HeaderLen+udpHeaderLen+len(payload))
+	copy(frame[6:12], net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+
+	ip := frame[etherHeaderLen:]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(udpHeaderLen+len(payload)))
+	ip[6] = nextHeader
+	ip[7] = 1
+	copy(ip[8:24], net.ParseIP("fe80::1"))
+	copy(ip[24:40], net.ParseIP(dst))
+
+	udp := ip[ipv6HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:2], uint16(dhcpv6.DefaultClientPort))
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLen+len(payload)))
+
+	return append(frame, payload...)
+}
+
+func TestDHCPv6Filter(t *testing.T) {
+	vm, err := bpf.NewVM(dhcpv6FilterInstructions())
+	if err != nil {
+		t.Fatalf("filter does not load: %v", err)
+	}
+
+	payload := []byte{byte(dhcpv6.MessageTypeSolicit), 0x00, 0x00, 0x01}
+	tests := []struct {
+		name   string
+		frame  []byte
+		accept bool
+	}{
+		{"all dhcp relay agents and servers", testFrame(etherTypeIPv6, 17, "ff02::1:2", 547, payload), true},
+		{"link-local unicast", testFrame(etherTypeIPv6, 17, "fe80::2", 547, payload), true},
+		{"global unicast", testFrame(etherTypeIPv6, 17, "2001:db8::1", 547, payload), true},
+		{"all dhcp servers", testFrame(etherTypeIPv6, 17, "ff05::1:3", 547, payload), false},
+		{"all nodes", testFrame(etherTypeIPv6, 17, "ff02::1", 547, payload), false},
+		{"other group on ff02::1:x", testFrame(etherTypeIPv6, 17, "ff02::1:3", 547, payload), false},
+		{"client port", testFrame(etherTypeIPv6, 17, "ff02::1:2", 546, payload), false},
+		{"unicast to other port", testFrame(etherTypeIPv6, 17, "fe80::2", 53, payload), false},
+		{"tcp", testFrame(etherTypeIPv6, 6, "ff02::1:2", 547, payload), false},
+		{"ipv4", testFrame(0x0800, 17, "ff02::1:2", 547, payload), false},
+	}
+	for _, tt := range tests {
+		n, err := vm.Run(tt.frame)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if accept := n > 0; accept != tt.accept {
+			t.Errorf("%s: accepted %v, want %v", tt.name, accept, tt.accept)
+		}
+	}
+}
+
+func TestParseEthernetFrame(t *testing.T) {
+	payload := []byte{byte(dhcpv6.MessageTypeSolicit), 0x00, 0x00, 0x01}
+	tests := []struct {
+		name  string
+		frame []byte
+		dst   net.IP
+		fails bool
+	}{
+		{"multicast", testFrame(etherTypeIPv6, 17, "ff02::1:2", 547, payload), dhcpv6.AllDHCPRelayAgentsAndServers, false},
+		{"link-local unicast", testFrame(etherTypeIPv6, 17, "fe80::2", 547, payload), net.ParseIP("fe80::2"), false},
+		{"global unicast", testFrame(etherTypeIPv6, 17, "2001:db8::1", 547, payload), net.ParseIP("2001:db8::1"), false},
+		{"other multicast group", testFrame(etherTypeIPv6, 17, "ff05::1:3", 547, payload), nil, true},
+		{"wrong port", testFrame(etherTypeIPv6, 17, "ff02::1:2", 546, payload), nil, true},
+		{"not udp", testFrame(etherTypeIPv6, 6, "ff02::1:2", 547, payload), nil, true},
+		{"not ipv6", testFrame(0x0800, 17, "ff02::1:2", 547, payload), nil, true},
+		{"empty payload", testFrame(etherTypeIPv6, 17, "ff02::1:2", 547, nil), nil, true},
+		{"truncated", testFrame(etherTypeIPv6, 17, "ff02::1:2", 547, payload)[:etherHeaderLen+ipv6HeaderLen+4], nil, true},
+	}
+	for _, tt := range tests {
+		mac, dhcp, peer, dst, err := parseEthernetFrame(tt.frame)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !dst.Equal(tt.dst) {
+			t.Errorf("%s: dst %v, want %v", tt.name, dst, tt.dst)
+		}
+		if mac.String() != "00:11:22:33:44:55" {
+			t.Errorf("%s: mac %v", tt.name, mac)
+		}
+		if !peer.IP.Equal(net.ParseIP("fe80::1")) || peer.Port != dhcpv6.DefaultClientPort {
+			t.Errorf("%s: peer %v", tt.name, peer)
+		}
+		if string(dhcp) != string(payload) {
+			t.Errorf("%s: payload %x, want %x", tt.name, dhcp, payload)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	ll "github.com/sirupsen/logrus"
@@ -18,10 +20,36 @@ const (
 )
 
 var (
-	dns listIP
+	dns               listIP
+	temporaryPrefixes listIPNet
+	trustedRelays     listIP
+	serverUnicast     net.IP
+	searchDomains     listDomain
+	ntpServers        listNTP
+	dnrResolvers      listDNR
+	ipxeParams        listString
+	uefiParams        listString
+	biosParams        listString
+	bootURLs          mapBootURL
 
 	versionFlag   = flag.Bool("version", false, "print dhcpd6-unnumbered version and exit")
-	flagLeaseTime = flag.Duration("leasetime", (30 * time.Minute), "DHCP lease time. aka Preffered Lifetime, Valid Lifetime x2")
+	flagLeaseTime = flag.Duration("leasetime", (30 * time.Minute), "DHCP lease time, the default for -preferred-lifetime")
+
+	flagPreferredLifetime = flag.Duration("preferred-lifetime", 0, "preferred lifetime of addresses and prefixes, defaults to leasetime")
+	flagValidLifetime     = flag.Duration("valid-lifetime", 0, "valid lifetime of addresses and prefixes, defaults to 2x preferred lifetime")
+	flagT1                = flag.Duration("t1", 0, "time after which clients renew (T1), defaults to .5x preferred lifetime")
+	flagT2                = flag.Duration("t2", 0, "time after which clients rebind (T2), defaults to .8x preferred lifetime")
+	flagRapidCommit       = flag.Bool("rapid-commit", true, "answer a Solicit carrying Rapid Commit with a committed Reply instead of an Advertise")
+	flagPreference        = flag.Uint("preference", 0, "server preference (0-255) sent in Advertise so clients pick us over other servers, 255 makes them pick us right away. 0 leaves the option out")
+	flagFQDNPolicy        = flag.String(
+		"fqdn-policy",
+		fqdnOverride,
+		fmt.Sprintf("what to do with names proposed by clients in the FQDN option. One of %v", fqdnPolicies),
+	)
+	flagSolMaxRT          = flag.Duration("sol-max-rt", 0, "SOL_MAX_RT sent to clients, caps how often they retry Solicit (60s-86400s). 0 leaves it to the client")
+	flagInfMaxRT          = flag.Duration("inf-max-rt", 0, "INF_MAX_RT sent to clients, caps how often they retry Information-Request (60s-86400s). 0 leaves it to the client")
+	flagInfoRefresh       = flag.Duration("information-refresh-time", 0, "tells stateless clients when to ask again, i.e. to pick up DNS changes (at least 600s). 0 leaves it to the client")
+	flagLifetimeFromRoute = flag.Bool("lifetime-from-route", false, "cap lifetimes, T1 and T2 at the expiry of temporary routes, i.e. ip -6 route add ... expires 3600")
 
 	flagDynHost          = flag.Bool("dynamic-hostname", false, "dynamic hostname generated from {IP/./-}.domainname")
 	flagHostnameOverride = flag.Bool(
@@ -40,11 +68,39 @@ var (
 		"static hostname to be handed out in dhcp offers, is ignored if dynamic-hostname is enabled",
 	)
 	flagDomainname       = flag.String("domain-name", "local", "domainname to be handed out in dhcp offers")
-	flagHTTPUrl          = flag.String("http-url", "", "url to serve UNDI http client (alias for bios-url)")
+	flagAFTRName         = flag.String("aftr-name", "", "DS-Lite AFTR name handed out to clients requesting it (RFC 6334)")
+	flagHTTPUrl          = flag.String("http-url", "", "deprecated, same as -boot-url bios=<url>")
 	flagiPXE             = flag.String("iPXE", "", "url to serve iPXE config (eg. boot.ipxe)")
-	flagBiosUrl          = flag.String("bios-url", "", "url to serve UNDI http client")
-	flagUefiUrl          = flag.String("uefi-url", "", "url to serve UEFI http client")
+	flagBiosUrl          = flag.String("bios-url", "", "deprecated, same as -boot-url bios=<url>")
+	flagUefiUrl          = flag.String("uefi-url", "", "deprecated, same as -boot-url uefi=<url>")
 	flagIgnoreVirtualMAC = flag.Bool("ignore-virtual-mac", true, "ignore DHCP requests from clients with locally-administered (virtual) source MAC addresses")
+	flagAssignment       = flag.String(
+		"address-assignment",
+		assignAll,
+		"how matching host routes are handed out over the IA_NAs of a client: 'all' spreads every address by IAID, 'single' hands out one address per IA_NA",
+	)
+	flagAcceptUnicast = flag.Bool("accept-unicast", false, "also answer clients sending to one of our own addresses instead of the all-servers multicast group. Relays may always unicast")
+	flagServerUnicast = flag.String(
+		"server-unicast",
+		"",
+		"address handed out in the Server Unicast option, telling clients to unicast to it. Requires accept-unicast",
+	)
+	flagReconfigure      = flag.Bool("reconfigure", false, "send Reconfigure messages to clients accepting them whenever the routes of their interface change")
+	flagReconfigureDelay = flag.Duration("reconfigure-delay", 2*time.Second, "how long routes of an interface have to be stable before clients are sent a Reconfigure")
+	flagQuarantine       = flag.Duration("decline-quarantine", time.Hour, "how long an address declined by a client (i.e. duplicate detected) is not offered again on that interface")
+
+	flagDUID = flag.String(
+		"duid",
+		"",
+		"server DUID as hex string (i.e. 00:02:00:00:ab:11:...), takes precedence over duid-file. Set the same DUID on every hypervisor so migrated clients keep talking to the \"same\" server",
+	)
+	flagDUIDFile = flag.String(
+		"duid-file",
+		"/var/lib/dhcpd6-unnumbered/server-duid",
+		"file the server DUID is read from on startup. If missing a new DUID is generated and stored there. Empty disables persistence",
+	)
+	flagDUIDEnterprise = flag.Uint("duid-enterprise-number", 0, "IANA enterprise number used when generating an en DUID")
+	flagDUIDInterface  = flag.String("duid-interface", "", "interface whose MAC is used when generating llt/ll DUIDs, defaults to the first interface with an ethernet address")
 
 	logLevels = map[string]func(){
 		"none":    func() { ll.SetOutput(ioutil.Discard) },
@@ -64,8 +120,43 @@ var (
 func main() {
 	flagLogLevel := flag.String("loglevel", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
 	flag.Var(&dns, "dns", "dns server to use in DHCP offer, option can be used multiple times for more than 1 server")
+	flag.Var(
+		&trustedRelays,
+		"trusted-relay",
+		"address of a relay whose Relay-Forward messages are answered, option can be used multiple times. Relay-Forward from anyone else is dropped",
+	)
+	flag.Var(
+		&temporaryPrefixes,
+		"temporary-prefix",
+		"host routes within this prefix form a temporary-address pool and are only handed out in IA_TA, option can be used multiple times",
+	)
+	flag.Var(
+		&searchDomains,
+		"search-domain",
+		"domain handed out in the Domain Search List, option can be used multiple times. Defaults to domain-name",
+	)
+	flag.Var(
+		&ntpServers,
+		"ntp",
+		"ntp server address, multicast address or name handed out in the NTP server option, option can be used multiple times",
+	)
+	flag.Var(
+		&dnrResolvers,
+		"dnr",
+		"encrypted dns resolver handed out in the DNR option, i.e. 'dns.example.com addrs=2620:fe::9 alpn=dot', option can be used multiple times",
+	)
+	flag.Var(
+		&bootURLs,
+		"boot-url",
+		"<arch>=<url> boot file url for a client architecture type number (RFC 4578), uefi or bios for any other UEFI or BIOS client, or default. option can be used multiple times",
+	)
+	flag.Var(&ipxeParams, "iPXE-param", "boot file parameter handed out along the iPXE url, option can be used multiple times")
+	flag.Var(&uefiParams, "uefi-param", "boot file parameter handed out along the uefi url, option can be used multiple times")
+	flag.Var(&biosParams, "bios-param", "boot file parameter handed out along the bios url, option can be used multiple times")
 	flagAcceptPrefix := flag.String("accept-prefix", "::/0", "IPv6 prefix to match host routes")
 	flagIfiRegex := flag.String("regex", "eth.*", "regex to match interfaces.")
+	flagConfig := flag.String("config", "", "optional json file with settings per interface, see README")
+	flagDUIDType := flag.String("duid-type", "llt", fmt.Sprintf("type of server DUID to generate if none is stored yet. One of %v", getDUIDTypes()))
 	flag.Parse()
 
 	if *versionFlag {
@@ -103,12 +194,71 @@ func main() {
 		ll.Infof("no DNS provided, using defaults")
 	}
 	ll.Infof("using DNS %v", dns)
+	if len(ntpServers) > 0 {
+		ll.Infof("using NTP %v", ntpServers)
+	}
+	if len(dnrResolvers) > 0 {
+		ll.Infof("using DNR %s", dnrResolvers.String())
+	}
+
+	if *flagPreference > 255 {
+		ll.Fatalf("invalid preference %d, has to be 0-255", *flagPreference)
+	}
+	// the old boot url flags fill the table unless it is given explicitly, bios-url wins over http-url
+	if *flagBiosUrl == "" {
+		*flagBiosUrl = *flagHTTPUrl
+	}
+	if bootURLs == nil {
+		bootURLs = mapBootURL{}
+	}
+	for k, v := range map[string]string{bootBIOS: *flagBiosUrl, bootUEFI: *flagUefiUrl, bootIPXE: *flagiPXE} {
+		if _, ok := bootURLs[k]; !ok && v != "" {
+			if err := validBootURL(v); err != nil {
+				ll.Fatalln(err)
+			}
+			bootURLs[k] = v
+		}
+	}
+	if *flagAFTRName != "" {
+		if err := validDomainName(*flagAFTRName); err != nil {
+			ll.Fatalf("invalid aftr-name %s: %v", *flagAFTRName, err)
+		}
+	}
+
+	if err := validAssignMode(*flagAssignment); err != nil {
+		ll.Fatalln(err)
+	}
+
+	if len(temporaryPrefixes) > 0 {
+		ll.Infof("using temporary-address pools %v", temporaryPrefixes)
+	}
+
+	if *flagServerUnicast != "" {
+		if !*flagAcceptUnicast {
+			ll.Fatalln("server-unicast requires accept-unicast")
+		}
+		serverUnicast = net.ParseIP(*flagServerUnicast)
+		if serverUnicast == nil || serverUnicast.To4() != nil {
+			ll.Fatalf("invalid server-unicast address %s", *flagServerUnicast)
+		}
+		ll.Infof("telling clients to unicast to %s", serverUnicast)
+	}
 
 	_, pfx, err := net.ParseCIDR(*flagAcceptPrefix)
 	if err != nil {
 		ll.Fatalf("unable to parse prefix: %v", err)
 	}
 
+	config, err := loadConfig(*flagConfig)
+	if err != nil {
+		ll.Fatalf("unable to load config: %v", err)
+	}
+
+	duid, err := loadServerDUID(*flagDUID, *flagDUIDFile, *flagDUIDType, uint32(*flagDUIDEnterprise), *flagDUIDInterface)
+	if err != nil {
+		ll.Fatalf("unable to get server duid: %v", err)
+	}
+
 	linksFeed := make(chan netlink.LinkUpdate, 10)
 	linksDone := make(chan struct{})
 
@@ -128,7 +278,19 @@ func main() {
 		ll.Fatalf("unable to get started: %v", err)
 	}
 
+	// route changes are only of interest when clients get told about them, a nil channel is never ready
+	var routesFeed chan netlink.RouteUpdate
+	if *flagReconfigure {
+		routesFeed = make(chan netlink.RouteUpdate, 10)
+		if err := netlink.RouteSubscribe(routesFeed, nil); err != nil {
+			ll.Fatalf("unable to open netlink route feed: %v", err)
+		}
+		ll.Infof("Reconfigure enabled, waiting %s for routes to settle", *flagReconfigureDelay)
+	}
+
 	e.Flags.SetPrefix(pfx)
+	e.Flags.SetServerID(duid)
+	e.Flags.SetConfig(config)
 
 	// when starting up making sure any already existing interfaces are being handled and started
 	for _, link := range t {
@@ -146,11 +308,27 @@ func main() {
 		}
 	}
 
+	// dump counters on demand, i.e. to see why messages got dropped
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+
 	// as we go on, detect any NIC changes from netlink and act accordingly
 	for {
 		select {
+		case <-sigs:
+			ll.Infof("counters: %s", stats)
 		case <-linksDone:
 			ll.Fatalln("netlink feed ended")
+		case route, ok := <-routesFeed:
+			if !ok {
+				ll.Fatalln("netlink route feed ended")
+			}
+			// only routes we could hand out matter
+			if route.Dst == nil || route.Dst.IP.To4() != nil || !pfx.Contains(route.Dst.IP) {
+				continue
+			}
+			ll.Tracef("Netlink route fired: %v", route)
+			e.RouteChanged(route.LinkIndex, *flagReconfigureDelay)
 		case link := <-linksFeed:
 			ifName := link.Attrs().Name
 			tapState := link.Attrs().OperState
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// validNTPServer checks s is an IPv6 (multicast) address or a name usable in the NTP server option
+func validNTPServer(s string) error {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return fmt.Errorf("%s is not an IPv6 address", s)
+		}
+		return nil
+	}
+	return validDomainName(s)
+}
+
+// ntpSuboption returns the RFC 5908 suboption for s: multicast and unicast addresses or a server name
+func ntpSuboption(s string) dhcpv6.Option {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.IsMulticast() {
+			so := dhcpv6.NTPSuboptionMCAddr(ip.To16())
+			return &so
+		}
+		so := dhcpv6.NTPSuboptionSrvAddr(ip.To16())
+		return &so
+	}
+	so := dhcpv6.NTPSuboptionSrvFQDN(rfc1035label.Labels{Labels: []string{strings.TrimSuffix(s, ".")}})
+	return &so
+}
+
+// ntpOption builds the NTP server option (RFC 5908) listing servers in the given order
+func ntpOption(servers []string) *dhcpv6.OptNTPServer {
+	o := &dhcpv6.OptNTPServer{}
+	for _, s := range servers {
+		o.Suboptions.Add(ntpSuboption(s))
+	}
+	return o
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// options the dhcpv6 library only knows the code of, they are sent as generic options
+
+// optUnicast is the Server Unicast option (RFC 8415 21.12) telling clients they may unicast to ip
+func optUnicast(ip net.IP) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionUnicast, OptionData: ip.To16()}
+}
+
+// optPreference is the Preference option (RFC 8415 21.8)
+func optPreference(p uint8) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionPreference, OptionData: []byte{p}}
+}
+
+// optReconfMessage is the Reconfigure Message option (RFC 8415 21.19) telling the client what to send
+func optReconfMessage(t dhcpv6.MessageType) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfMessage, OptionData: []byte{byte(t)}}
+}
+
+// optReconfAccept is the Reconfigure Accept option (RFC 8415 21.20), it has no data
+func optReconfAccept() dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept}
+}
+
+// optSolMaxRT is the SOL_MAX_RT option (RFC 8415 21.24)
+func optSolMaxRT(d time.Duration) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionSolMaxRT, OptionData: seconds(d)}
+}
+
+// optInfMaxRT is the INF_MAX_RT option (RFC 8415 21.25)
+func optInfMaxRT(d time.Duration) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionInfMaxRT, OptionData: seconds(d)}
+}
+
+// seconds encodes d as 32bit seconds
+func seconds(d time.Duration) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(d/time.Second))
+	return b
+}
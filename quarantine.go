@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Quarantine keeps track of addresses clients declined (i.e. DAD detected a duplicate) per interface,
+// so we don't offer them again until the quarantine expired - thread safe
+type Quarantine struct {
+	q    map[string]time.Time
+	lock sync.Mutex
+}
+
+// NewQuarantine just sets up an empty quarantine
+func NewQuarantine() *Quarantine {
+	return &Quarantine{
+		q: make(map[string]time.Time),
+	}
+}
+
+func quarantineKey(ifName string, ip net.IP) string {
+	return ifName + "%" + ip.String()
+}
+
+// Add quarantines ip on ifName for d, expired entries of all interfaces are dropped on the way
+func (q *Quarantine) Add(ifName string, ip net.IP, d time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	for k, until := range q.q {
+		if now.After(until) {
+			delete(q.q, k)
+		}
+	}
+	q.q[quarantineKey(ifName, ip)] = now.Add(d)
+}
+
+// Contains checks if ip is quarantined on ifName, expired entries are cleaned up on the way
+func (q *Quarantine) Contains(ifName string, ip net.IP) bool {
+	k := quarantineKey(ifName, ip)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	until, ok := q.q[k]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(q.q, k)
+		return false
+	}
+	return true
+}
+
+// Filter returns all ips not quarantined on ifName
+func (q *Quarantine) Filter(ifName string, ips []net.IP) []net.IP {
+	var r []net.IP
+	for _, ip := range ips {
+		if !q.Contains(ifName, ip) {
+			r = append(r, ip)
+		}
+	}
+	return r
+}
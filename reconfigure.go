@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	ll "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv6"
+)
+
+// Reconfigure Key Authentication Protocol, RFC 8415 20.4
+const (
+	authProtocolRKAP  = 3
+	authAlgorithmMD5  = 1
+	authRDMCounter    = 0
+	rkapKeyValue      = 1
+	rkapHMACMD5       = 2
+	reconfigureKeyLen = 16
+
+	// RFC 8415 7.6 REC_TIMEOUT and REC_MAX_RC
+	reconfigureTimeout = 2 * time.Second
+	reconfigureMaxRC   = 8
+)
+
+type reconfigureClient struct {
+	clientID dhcpv6.Duid
+	ip       net.IP
+	key      []byte
+	seen     time.Time
+	expires  time.Time
+	sending  bool
+}
+
+// Reconfigures keeps the reconfigure keys handed out to clients accepting Reconfigure messages,
+// per interface so they can be asked to renew when the routes of their interface change - thread safe
+type Reconfigures struct {
+	clients map[string]map[string]*reconfigureClient
+	replay  uint64
+	lock    sync.Mutex
+}
+
+// NewReconfigures just sets up an empty set of clients
+func NewReconfigures() *Reconfigures {
+	return &Reconfigures{
+		clients: make(map[string]map[string]*reconfigureClient),
+		// replay detection has to increase monotonically, starting at the current time keeps it going up across restarts
+		replay: uint64(time.Now().UnixNano()),
+	}
+}
+
+// Bind returns the reconfigure key of the client identified by cid on ifName, generating one for clients not known yet.
+// the client is forgotten once valid passed without it coming back
+func (r *Reconfigures) Bind(ifName string, cid *dhcpv6.Duid, ip net.IP, valid time.Duration) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.clients[ifName] == nil {
+		r.clients[ifName] = make(map[string]*reconfigureClient)
+	}
+	k := string(cid.ToBytes())
+	c, ok := r.clients[ifName][k]
+	if !ok || time.Now().After(c.expires) {
+		key := make([]byte, reconfigureKeyLen)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("unable to generate reconfigure key: %w", err)
+		}
+		c = &reconfigureClient{clientID: *cid, key: key}
+		r.clients[ifName][k] = c
+	}
+	c.ip = ip
+	c.seen = time.Now()
+	c.expires = c.seen.Add(valid)
+	return c.key, nil
+}
+
+// Forget drops the client identified by cid on ifName, i.e. after it released its addresses
+func (r *Reconfigures) Forget(ifName string, cid *dhcpv6.Duid) {
+	if cid == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.clients[ifName], string(cid.ToBytes()))
+}
+
+// Clients returns copies of the clients still bound on ifName, expired ones are cleaned up on the way
+func (r *Reconfigures) Clients(ifName string) []reconfigureClient {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var cs []reconfigureClient
+	for k, c := range r.clients[ifName] {
+		if time.Now().After(c.expires) {
+			delete(r.clients[ifName], k)
+			continue
+		}
+		cs = append(cs, *c)
+	}
+	return cs
+}
+
+// ForgetInterface drops every client of ifName, i.e. once the interface is gone
+func (r *Reconfigures) ForgetInterface(ifName string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.clients, ifName)
+}
+
+// claim marks a Reconfigure to the client as in flight, false if there is one already
+func (r *Reconfigures) claim(ifName string, cid dhcpv6.Duid) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	c, ok := r.clients[ifName][string(cid.ToBytes())]
+	if !ok || c.sending {
+		return false
+	}
+	c.sending = true
+	return true
+}
+
+// release marks the Reconfigure to the client as done
+func (r *Reconfigures) release(ifName string, cid dhcpv6.Duid) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c, ok := r.clients[ifName][string(cid.ToBytes())]; ok {
+		c.sending = false
+	}
+}
+
+// SeenSince checks if the client came back (i.e. renewed) after t
+func (r *Reconfigures) SeenSince(ifName string, cid dhcpv6.Duid, t time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	c, ok := r.clients[ifName][string(cid.ToBytes())]
+	return !ok || c.seen.After(t)
+}
+
+func (r *Reconfigures) nextReplay() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.replay++
+	return r.replay
+}
+
+// authOption builds an Authentication option (RFC 8415 21.11) using RKAP
+func authOption(replay uint64, infoType byte, value []byte) *dhcpv6.OptionGeneric {
+	b := make([]byte, 12+len(value))
+	b[0] = authProtocolRKAP
+	b[1] = authAlgorithmMD5
+	b[2] = authRDMCounter
+	binary.BigEndian.PutUint64(b[3:11], replay)
+	b[11] = infoType
+	copy(b[12:], value)
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: b}
+}
+
+// KeyOption hands key to the client in the Reply it accepted Reconfigure in
+func (r *Reconfigures) KeyOption(key []byte) dhcpv6.Option {
+	return authOption(r.nextReplay(), rkapKeyValue, key)
+}
+
+// newReconfigure builds a Reconfigure telling the client to renew, signed with the key it got from us
+func (r *Reconfigures) newReconfigure(serverID *dhcpv6.Duid, c reconfigureClient) *dhcpv6.Message {
+	// RFC 8415 18.3.11, the transaction-id of a Reconfigure is always 0
+	msg := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReconfigure}
+	msg.AddOption(dhcpv6.OptServerID(*serverID))
+	msg.AddOption(dhcpv6.OptClientID(c.clientID))
+	msg.AddOption(optReconfMessage(dhcpv6.MessageTypeRenew))
+
+	// the HMAC is calculated over the whole message with the digest zeroed out
+	auth := authOption(r.nextReplay(), rkapHMACMD5, make([]byte, md5.Size))
+	msg.AddOption(auth)
+	mac := hmac.New(md5.New, c.key)
+	mac.Write(msg.ToBytes())
+	copy(auth.OptionData[12:], mac.Sum(nil))
+	return msg
+}
+
+// reconfigure asks every client bound on the interface to renew right away, retransmitting until it did.
+// clients still being sent a Reconfigure are skipped, renewing once picks up every change anyway
+func (l *Listener) reconfigure() {
+	for _, c := range l.Flags.reconfigures.Clients(l.ifi.Name) {
+		if !l.Flags.reconfigures.claim(l.ifi.Name, c.clientID) {
+			ll.Debugf("reconfigure: %s on %s has one in flight already", c.ip, l.ifi.Name)
+			continue
+		}
+		go func(c reconfigureClient) {
+			defer l.Flags.reconfigures.release(l.ifi.Name, c.clientID)
+			start := time.Now()
+			peer := &net.UDPAddr{IP: c.ip, Port: dhcpv6.DefaultClientPort, Zone: l.ifi.Name}
+			timeout := reconfigureTimeout
+			for i := 0; i < reconfigureMaxRC; i++ {
+				msg := l.Flags.reconfigures.newReconfigure(l.Flags.serverID, c)
+				n := stats.Inc("reconfigure")
+				ll.Infof("%s to %s on %s (%d so far)", msg.Type(), c.ip, l.ifi.Name, n)
+				ll.Trace(msg.Summary())
+				l.send(msg, nil, &ipv6.ControlMessage{IfIndex: l.ifi.Index}, peer)
+
+				time.Sleep(timeout)
+				if l.Flags.reconfigures.SeenSince(l.ifi.Name, c.clientID, start) {
+					return
+				}
+				timeout *= 2
+			}
+			ll.Warnf("reconfigure: %s on %s did not renew after %d attempts", c.ip, l.ifi.Name, reconfigureMaxRC)
+		}(c)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	ll "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// innermostRelay returns the Relay-Forward added by the relay closest to the client
+func innermostRelay(r *dhcpv6.RelayMessage) *dhcpv6.RelayMessage {
+	for {
+		inner, ok := r.Options.RelayMessage().(*dhcpv6.RelayMessage)
+		if !ok {
+			return r
+		}
+		r = inner
+	}
+}
+
+// relayInterface picks the interface whose routes are used to answer a relayed request.
+// an Interface-ID naming a handled interface wins, then the interface the relay's link-address is routed to.
+// if neither qualifies we answer for the interface the request came in on
+func (l *Listener) relayInterface(r *dhcpv6.RelayMessage) *net.Interface {
+	if id := r.Options.InterfaceID(); len(id) > 0 {
+		if ifi, err := net.InterfaceByName(string(id)); err == nil && l.Flags.regex.MatchString(ifi.Name) {
+			return ifi
+		}
+		ll.Debugf("handleMsg6: relay interface-id %q is not a handled interface", id)
+	}
+
+	if r.LinkAddr != nil && !r.LinkAddr.IsUnspecified() && !r.LinkAddr.IsLinkLocalUnicast() {
+		routes, err := netlink.RouteGet(r.LinkAddr)
+		if err != nil {
+			ll.Debugf("handleMsg6: unable to look up route to relay link-address %s: %v", r.LinkAddr, err)
+		}
+		for _, route := range routes {
+			ifi, err := net.InterfaceByIndex(route.LinkIndex)
+			if err == nil && l.Flags.regex.MatchString(ifi.Name) {
+				return ifi
+			}
+		}
+	}
+	return l.ifi
+}
+
+// newRelayReply wraps msg into Relay-Reply messages mirroring every level of forw.
+// hop-count, link- and peer-address as well as the Interface-ID are echoed back as is (RFC 8415 19.3)
+func newRelayReply(forw *dhcpv6.RelayMessage, msg *dhcpv6.Message) (*dhcpv6.RelayMessage, error) {
+	if forw.Type() != dhcpv6.MessageTypeRelayForward {
+		return nil, fmt.Errorf("%s is not a relay-forward", forw.Type())
+	}
+	var payload dhcpv6.DHCPv6 = msg
+	switch inner := forw.Options.RelayMessage().(type) {
+	case *dhcpv6.RelayMessage:
+		p, err := newRelayReply(inner, msg)
+		if err != nil {
+			return nil, err
+		}
+		payload = p
+	case nil:
+		return nil, fmt.Errorf("malformed relay-forward: no relay message option")
+	}
+
+	repl := &dhcpv6.RelayMessage{
+		MessageType: dhcpv6.MessageTypeRelayReply,
+		HopCount:    forw.HopCount,
+		LinkAddr:    forw.LinkAddr,
+		PeerAddr:    forw.PeerAddr,
+	}
+	if id := forw.GetOneOption(dhcpv6.OptionInterfaceID); id != nil {
+		repl.AddOption(id)
+	}
+	repl.AddOption(dhcpv6.OptRelayMessage(payload))
+	return repl, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestNewRelayReply(t *testing.T) {
+	request, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.MessageType = dhcpv6.MessageTypeRequest
+
+	// client -> relay 1 (closest to the client, Interface-ID "guest0") -> relay 2 (Interface-ID "uplink")
+	inner, err := dhcpv6.EncapsulateRelay(request, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.AddOption(dhcpv6.OptInterfaceID([]byte("guest0")))
+	outer, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8:1::fe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer.AddOption(dhcpv6.OptInterfaceID([]byte("uplink")))
+
+	reply, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.MessageType = dhcpv6.MessageTypeReply
+	reply.TransactionID = request.TransactionID
+	repl, err := newRelayReply(outer, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what goes out on the wire has to parse back the same way
+	parsed, err := dhcpv6.FromBytes(repl.ToBytes())
+	if err != nil {
+		t.Fatalf("relay-reply does not parse: %v", err)
+	}
+
+	levels := []struct {
+		hops  uint8
+		link  string
+		peer  string
+		ifID  string
+		inner dhcpv6.MessageType
+	}{
+		{1, "2001:db8:2::1", "2001:db8:1::fe", "uplink", dhcpv6.MessageTypeRelayReply},
+		{0, "2001:db8:1::1", "fe80::1", "guest0", dhcpv6.MessageTypeReply},
+	}
+	r, ok := parsed.(*dhcpv6.RelayMessage)
+	for i, want := range levels {
+		if !ok {
+			t.Fatalf("level %d: not a relay message", i)
+		}
+		if r.Type() != dhcpv6.MessageTypeRelayReply {
+			t.Errorf("level %d: type %s, want %s", i, r.Type(), dhcpv6.MessageTypeRelayReply)
+		}
+		if r.HopCount != want.hops {
+			t.Errorf("level %d: hop-count %d, want %d", i, r.HopCount, want.hops)
+		}
+		if !r.LinkAddr.Equal(net.ParseIP(want.link)) {
+			t.Errorf("level %d: link-address %s, want %s", i, r.LinkAddr, want.link)
+		}
+		if !r.PeerAddr.Equal(net.ParseIP(want.peer)) {
+			t.Errorf("level %d: peer-address %s, want %s", i, r.PeerAddr, want.peer)
+		}
+		if id := string(r.Options.InterfaceID()); id != want.ifID {
+			t.Errorf("level %d: interface-id %q, want %q", i, id, want.ifID)
+		}
+		payload := r.Options.RelayMessage()
+		if payload == nil {
+			t.Fatalf("level %d: no relay message", i)
+		}
+		if payload.Type() != want.inner {
+			t.Errorf("level %d: relays %s, want %s", i, payload.Type(), want.inner)
+		}
+		r, ok = payload.(*dhcpv6.RelayMessage)
+	}
+
+	msg, err := parsed.GetInnerMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.TransactionID != request.TransactionID {
+		t.Errorf("transaction id %s, want %s", msg.TransactionID, request.TransactionID)
+	}
+}
+
+func TestNewRelayReplyMalformed(t *testing.T) {
+	reply, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty := &dhcpv6.RelayMessage{MessageType: dhcpv6.MessageTypeRelayForward}
+	if _, err := newRelayReply(empty, reply); err == nil {
+		t.Error("relay-forward without relay message: no error")
+	}
+
+	repl := &dhcpv6.RelayMessage{MessageType: dhcpv6.MessageTypeRelayReply}
+	repl.AddOption(dhcpv6.OptRelayMessage(reply))
+	if _, err := newRelayReply(repl, reply); err == nil {
+		t.Error("relay-reply: no error")
+	}
+}
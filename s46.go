@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// optAFTRName is the DS-Lite AFTR-Name option (RFC 6334)
+func optAFTRName(name string) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionAFTRName,
+		OptionData: (&rfc1035label.Labels{Labels: []string{strings.TrimSuffix(name, ".")}}).ToBytes(),
+	}
+}
+
+// S46Config are the softwire mechanisms (RFC 7598) offered to clients, each one is sent if the client requests its container
+type S46Config struct {
+	MapE  *S46Map   `json:"map_e,omitempty"`
+	MapT  *S46Map   `json:"map_t,omitempty"`
+	LW4o6 *S46LW4o6 `json:"lw4o6,omitempty"`
+}
+
+// S46Map is a MAP-E or MAP-T domain. MAP-E needs border relays, MAP-T the default mapping rule instead
+type S46Map struct {
+	Rules []S46Rule `json:"rules"`
+	BR    []string  `json:"br,omitempty"`
+	DMR   string    `json:"dmr,omitempty"`
+}
+
+// S46Rule is a mapping rule, the BMR and FMRs if fmr is set
+type S46Rule struct {
+	IPv4Prefix string         `json:"ipv4_prefix"`
+	IPv6Prefix string         `json:"ipv6_prefix"`
+	EALen      uint8          `json:"ea_len"`
+	FMR        bool           `json:"fmr,omitempty"`
+	PortParams *S46PortParams `json:"port_params,omitempty"`
+}
+
+// S46LW4o6 is the Lightweight 4over6 binding of a client, bind_prefix defaults to the address handed out
+type S46LW4o6 struct {
+	IPv4Address string         `json:"ipv4_address"`
+	BindPrefix  string         `json:"bind_prefix,omitempty"`
+	PortParams  *S46PortParams `json:"port_params,omitempty"`
+	BR          []string       `json:"br"`
+}
+
+// S46PortParams is the port set given by PSID offset, PSID length and PSID (RFC 7597 5.1)
+type S46PortParams struct {
+	Offset  uint8  `json:"offset"`
+	PSIDLen uint8  `json:"psid_len"`
+	PSID    uint16 `json:"psid"`
+}
+
+// validate checks the whole configuration so encoding can't fail later on
+func (c *S46Config) validate() error {
+	if c.MapE != nil {
+		if err := c.MapE.validate(); err != nil {
+			return fmt.Errorf("map_e: %w", err)
+		}
+		if len(c.MapE.BR) == 0 || c.MapE.DMR != "" {
+			return fmt.Errorf("map_e needs br and no dmr")
+		}
+	}
+	if c.MapT != nil {
+		if err := c.MapT.validate(); err != nil {
+			return fmt.Errorf("map_t: %w", err)
+		}
+		if c.MapT.DMR == "" || len(c.MapT.BR) > 0 {
+			return fmt.Errorf("map_t needs a dmr and no br")
+		}
+	}
+	if c.LW4o6 != nil {
+		if err := c.LW4o6.validate(); err != nil {
+			return fmt.Errorf("lw4o6: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *S46Map) validate() error {
+	if len(m.Rules) == 0 {
+		return fmt.Errorf("no rules")
+	}
+	for _, r := range m.Rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validBRs(m.BR); err != nil {
+		return err
+	}
+	if m.DMR != "" {
+		if _, err := parseIPv6Prefix(m.DMR); err != nil {
+			return fmt.Errorf("invalid dmr: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r S46Rule) validate() error {
+	_, p4, err := net.ParseCIDR(r.IPv4Prefix)
+	if err != nil || p4.IP.To4() == nil {
+		return fmt.Errorf("invalid ipv4_prefix %s", r.IPv4Prefix)
+	}
+	p6, err := parseIPv6Prefix(r.IPv6Prefix)
+	if err != nil {
+		return fmt.Errorf("invalid ipv6_prefix: %w", err)
+	}
+	if l, _ := p6.Mask.Size(); l+int(r.EALen) > 128 {
+		return fmt.Errorf("ea_len %d doesn't fit behind %s", r.EALen, r.IPv6Prefix)
+	}
+	if r.PortParams != nil {
+		return r.PortParams.validate()
+	}
+	return nil
+}
+
+func (l *S46LW4o6) validate() error {
+	if ip := net.ParseIP(l.IPv4Address); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid ipv4_address %s", l.IPv4Address)
+	}
+	if l.BindPrefix != "" {
+		if _, err := parseIPv6Prefix(l.BindPrefix); err != nil {
+			return fmt.Errorf("invalid bind_prefix: %w", err)
+		}
+	}
+	if len(l.BR) == 0 {
+		return fmt.Errorf("no br")
+	}
+	if err := validBRs(l.BR); err != nil {
+		return err
+	}
+	if l.PortParams != nil {
+		return l.PortParams.validate()
+	}
+	return nil
+}
+
+func (p S46PortParams) validate() error {
+	if p.Offset > 15 || int(p.Offset)+int(p.PSIDLen) > 16 {
+		return fmt.Errorf("offset %d and psid_len %d exceed 16 bits", p.Offset, p.PSIDLen)
+	}
+	if p.PSIDLen < 16 && p.PSID >= 1<<p.PSIDLen {
+		return fmt.Errorf("psid %d doesn't fit in %d bits", p.PSID, p.PSIDLen)
+	}
+	return nil
+}
+
+func validBRs(brs []string) error {
+	for _, br := range brs {
+		if ip := net.ParseIP(br); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid br %s", br)
+		}
+	}
+	return nil
+}
+
+func parseIPv6Prefix(s string) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if n.IP.To4() != nil {
+		return nil, fmt.Errorf("%s is not an IPv6 prefix", s)
+	}
+	return n, nil
+}
+
+// s46Options returns the containers requested by msg. lw4o6 without bind_prefix is bound to ip and left out without it
+func s46Options(msg *dhcpv6.Message, c *S46Config, ip net.IP) []dhcpv6.Option {
+	if c == nil {
+		return nil
+	}
+	var opts []dhcpv6.Option
+	if c.MapE != nil && msg.IsOptionRequested(dhcpv6.OptionS46ContMapE) {
+		opts = append(opts, container(dhcpv6.OptionS46ContMapE, c.MapE.options()))
+	}
+	if c.MapT != nil && msg.IsOptionRequested(dhcpv6.OptionS46ContMapT) {
+		opts = append(opts, container(dhcpv6.OptionS46ContMapT, c.MapT.options()))
+	}
+	if c.LW4o6 != nil && msg.IsOptionRequested(dhcpv6.OptionS46ContLW) && (c.LW4o6.BindPrefix != "" || ip != nil) {
+		opts = append(opts, container(dhcpv6.OptionS46ContLW, c.LW4o6.options(ip)))
+	}
+	return opts
+}
+
+func container(code dhcpv6.OptionCode, opts dhcpv6.Options) dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{OptionCode: code, OptionData: opts.ToBytes()}
+}
+
+// options are the rules followed by the BRs (MAP-E) or the DMR (MAP-T)
+func (m *S46Map) options() dhcpv6.Options {
+	var opts dhcpv6.Options
+	for _, r := range m.Rules {
+		opts.Add(r.option())
+	}
+	opts = append(opts, brOptions(m.BR)...)
+	if m.DMR != "" {
+		n, _ := parseIPv6Prefix(m.DMR)
+		opts.Add(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionS46DMR, OptionData: prefixBytes(n)})
+	}
+	return opts
+}
+
+// option is OPTION_S46_RULE (RFC 7598 4.1)
+func (r S46Rule) option() dhcpv6.Option {
+	_, p4, _ := net.ParseCIDR(r.IPv4Prefix)
+	p6, _ := parseIPv6Prefix(r.IPv6Prefix)
+	l4, _ := p4.Mask.Size()
+
+	var flags byte
+	if r.FMR {
+		flags = 1
+	}
+	b := []byte{flags, r.EALen, byte(l4)}
+	b = append(b, p4.IP.To4()...)
+	b = append(b, prefixBytes(p6)...)
+	if r.PortParams != nil {
+		b = append(b, dhcpv6.Options{r.PortParams.option()}.ToBytes()...)
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionS46Rule, OptionData: b}
+}
+
+// options are OPTION_S46_V4V6BIND (RFC 7598 4.4) followed by the BRs
+func (l *S46LW4o6) options(ip net.IP) dhcpv6.Options {
+	bind := hostRoute(ip)
+	if l.BindPrefix != "" {
+		bind, _ = parseIPv6Prefix(l.BindPrefix)
+	}
+	b := append([]byte{}, net.ParseIP(l.IPv4Address).To4()...)
+	b = append(b, prefixBytes(bind)...)
+	if l.PortParams != nil {
+		b = append(b, dhcpv6.Options{l.PortParams.option()}.ToBytes()...)
+	}
+	opts := dhcpv6.Options{&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionS46V4V6Bind, OptionData: b}}
+	return append(opts, brOptions(l.BR)...)
+}
+
+// option is OPTION_S46_PORTPARAMS (RFC 7598 4.5), the PSID is left aligned
+func (p S46PortParams) option() dhcpv6.Option {
+	psid := uint16(0)
+	if p.PSIDLen > 0 {
+		psid = p.PSID << (16 - p.PSIDLen)
+	}
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionS46PortParams,
+		OptionData: []byte{p.Offset, p.PSIDLen, byte(psid >> 8), byte(psid)},
+	}
+}
+
+func brOptions(brs []string) dhcpv6.Options {
+	var opts dhcpv6.Options
+	for _, br := range brs {
+		opts.Add(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionS46BR, OptionData: net.ParseIP(br).To16()})
+	}
+	return opts
+}
+
+// prefixBytes encodes n as its length followed by the significant octets of the prefix
+func prefixBytes(n *net.IPNet) []byte {
+	l, _ := n.Mask.Size()
+	return append([]byte{byte(l)}, n.IP.To16()[:(l+7)/8]...)
+}
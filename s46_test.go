@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestS46PortParams(t *testing.T) {
+	tests := []struct {
+		name string
+		p    S46PortParams
+		want []byte
+	}{
+		// RFC 7598 4.5: offset, PSID-len, PSID left aligned and padded with zeros
+		{"psid 8 bits", S46PortParams{Offset: 6, PSIDLen: 8, PSID: 0x34}, []byte{0x00, 0x5d, 0x00, 0x04, 0x06, 0x08, 0x34, 0x00}},
+		{"psid 4 bits", S46PortParams{Offset: 0, PSIDLen: 4, PSID: 0x3}, []byte{0x00, 0x5d, 0x00, 0x04, 0x00, 0x04, 0x30, 0x00}},
+		{"psid 12 bits", S46PortParams{Offset: 4, PSIDLen: 12, PSID: 0xabc}, []byte{0x00, 0x5d, 0x00, 0x04, 0x04, 0x0c, 0xab, 0xc0}},
+		{"psid 16 bits", S46PortParams{Offset: 0, PSIDLen: 16, PSID: 0xabcd}, []byte{0x00, 0x5d, 0x00, 0x04, 0x00, 0x10, 0xab, 0xcd}},
+		{"no psid", S46PortParams{Offset: 6}, []byte{0x00, 0x5d, 0x00, 0x04, 0x06, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		if got := wire(tt.p.option()); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixBytes(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+	}{
+		{"::/0", []byte{0}},
+		{"2001:db8::/32", []byte{32, 0x20, 0x01, 0x0d, 0xb8}},
+		{"2001:db8:100::/40", []byte{40, 0x20, 0x01, 0x0d, 0xb8, 0x01}},
+		// partial octets are carried in full
+		{"2001:db8:8000::/33", []byte{33, 0x20, 0x01, 0x0d, 0xb8, 0x80}},
+		{"64:ff9b::/64", []byte{64, 0x00, 0x64, 0xff, 0x9b, 0, 0, 0, 0}},
+		{"2001:db8::1/128", []byte{128, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+	}
+	for _, tt := range tests {
+		_, n, err := net.ParseCIDR(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := prefixBytes(n); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestS46Rule(t *testing.T) {
+	tests := []struct {
+		name string
+		r    S46Rule
+		want []byte
+	}{
+		// RFC 7598 4.1: flags, ea-len, prefix4-len, ipv4-prefix, prefix6-len, ipv6-prefix, options
+		{
+			"fmr",
+			S46Rule{IPv4Prefix: "192.0.2.0/24", IPv6Prefix: "2001:db8:100::/40", EALen: 16, FMR: true},
+			[]byte{
+				0x00, 0x59, 0x00, 0x0d,
+				0x01, 16, 24, 192, 0, 2, 0,
+				40, 0x20, 0x01, 0x0d, 0xb8, 0x01,
+			},
+		},
+		{
+			"port params",
+			S46Rule{
+				IPv4Prefix: "198.51.100.0/24",
+				IPv6Prefix: "2001:db8:200::/48",
+				EALen:      8,
+				PortParams: &S46PortParams{Offset: 6, PSIDLen: 8, PSID: 5},
+			},
+			[]byte{
+				0x00, 0x59, 0x00, 0x16,
+				0x00, 8, 24, 198, 51, 100, 0,
+				48, 0x20, 0x01, 0x0d, 0xb8, 0x02, 0x00,
+				0x00, 0x5d, 0x00, 0x04, 0x06, 0x08, 0x05, 0x00,
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := wire(tt.r.option()); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestS46LW4o6(t *testing.T) {
+	br := []byte{0x00, 0x5a, 0x00, 0x10, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfe}
+	tests := []struct {
+		name string
+		l    S46LW4o6
+		ip   net.IP
+		want []byte
+	}{
+		// RFC 7598 4.4: ipv4-address, bindprefix6-len, bind-ipv6-prefix, options. followed by the BR (4.2)
+		{
+			"bound to the address handed out",
+			S46LW4o6{IPv4Address: "203.0.113.7", BR: []string{"2001:db8::fe"}},
+			net.ParseIP("2001:db8::1"),
+			append([]byte{
+				0x00, 0x5c, 0x00, 0x15,
+				203, 0, 113, 7,
+				128, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+			}, br...),
+		},
+		{
+			"bind prefix and port params",
+			S46LW4o6{
+				IPv4Address: "203.0.113.7",
+				BindPrefix:  "2001:db8:1::/48",
+				PortParams:  &S46PortParams{PSIDLen: 4, PSID: 3},
+				BR:          []string{"2001:db8::fe"},
+			},
+			nil,
+			append([]byte{
+				0x00, 0x5c, 0x00, 0x13,
+				203, 0, 113, 7,
+				48, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01,
+				0x00, 0x5d, 0x00, 0x04, 0x00, 0x04, 0x30, 0x00,
+			}, br...),
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.l.options(tt.ip).ToBytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestS46MapTContainer(t *testing.T) {
+	c := &S46Config{MapT: &S46Map{
+		Rules: []S46Rule{{IPv4Prefix: "198.51.100.0/24", IPv6Prefix: "2001:db8:200::/48", EALen: 8}},
+		DMR:   "64:ff9b::/64",
+	}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dhcpv6.NewMessage(dhcpv6.WithRequestedOptions(dhcpv6.OptionS46ContMapT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := s46Options(msg, c, nil)
+	if len(opts) != 1 {
+		t.Fatalf("got %d options, want 1", len(opts))
+	}
+	// RFC 7598 5.2: the rules followed by the DMR (4.3)
+	want := []byte{
+		0x00, 0x5f, 0x00, 0x1f,
+		0x00, 0x59, 0x00, 0x0e,
+		0x00, 8, 24, 198, 51, 100, 0,
+		48, 0x20, 0x01, 0x0d, 0xb8, 0x02, 0x00,
+		0x00, 0x5b, 0x00, 0x09,
+		64, 0x00, 0x64, 0xff, 0x9b, 0, 0, 0, 0,
+	}
+	if got := wire(opts...); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// iaTAOptions answers every IA_TA of msg with an address out of the temporary-address pools.
+// every IA_TA gets one address picked by its IAID, so a client asking with a fresh IAID may end up with another address
+func iaTAOptions(msg *dhcpv6.Message, temp []net.IP, lt lifetimesFunc) []dhcpv6.Option {
+	var opts []dhcpv6.Option
+	for _, ia := range msg.Options.IATA() {
+		r := &dhcpv6.OptIATA{IaId: ia.IaId}
+
+		var clientAddrs []net.IP
+		for _, a := range ia.Options.Addresses() {
+			clientAddrs = append(clientAddrs, a.IPv6Addr)
+		}
+		var ips []net.IP
+		switch b := iaBinding(msg.Type(), len(clientAddrs), !containsAllIPs(temp, clientAddrs)); b {
+		case bindingUnknown:
+			r.Options.Add(b.status())
+		case bindingGone:
+			// returning the addresses with lifetimes of 0 makes the client drop them right away
+			for _, ip := range clientAddrs {
+				r.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: ip})
+			}
+			r.Options.Add(b.status())
+		case bindingKnown:
+			// keep extending what the client has
+			ips = clientAddrs
+		default:
+			if len(temp) == 0 {
+				r.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail, StatusMessage: "no temporary addresses available"})
+				break
+			}
+			ips = []net.IP{temp[int(binary.BigEndian.Uint32(ia.IaId[:])%uint32(len(temp)))]}
+		}
+
+		for _, ip := range ips {
+			l := lt(hostRoute(ip))
+			r.Options.Add(&dhcpv6.OptIAAddress{
+				IPv6Addr:          ip,
+				PreferredLifetime: l.preferred,
+				ValidLifetime:     l.valid,
+			})
+		}
+		opts = append(opts, r)
+	}
+	return opts
+}
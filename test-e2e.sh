@@ -147,7 +147,7 @@ if [[ "$SRV_OPER" != "up" ]]; then
          "linkReady() requires OperUp — daemon may not bind to this interface."
 fi
 
-# Add the /128 host route that the daemon reads via getHostRoutesIPv6() to
+# Add the /128 host route that the daemon reads via getRoutesIPv6() to
 # decide which address to offer.  Without a matching route, the daemon logs
 # "no host routes" and silently drops all solicits.
 ip -6 route add "$HOST_ROUTE" dev "$SRV_IF"
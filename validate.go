@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	ll "github.com/sirupsen/logrus"
+)
+
+// reasons a message is dropped during validation, used as counter names as well
+const (
+	dropClientMessageType = "not-a-client-message"
+	dropNoClientID        = "missing-client-id"
+	dropNoServerID        = "missing-server-id"
+	dropServerIDPresent   = "unexpected-server-id"
+	dropServerIDMismatch  = "other-server-id"
+	dropIAPresent         = "unexpected-ia"
+	dropUnicast           = "unicast-not-allowed"
+	dropStatelessOnly     = "stateless-only"
+	dropUntrustedRelay    = "untrusted-relay"
+)
+
+// drop counts and logs msg from clientIP on ifi being discarded for reason
+func drop(msg dhcpv6.DHCPv6, clientIP net.IP, ifi *net.Interface, reason string) {
+	n := stats.Inc("drop." + reason)
+	ll.Infof("handleMsg6: dropping %s from %s on %s: %s (%d so far)", msg.Type(), clientIP, ifi.Name, reason, n)
+}
+
+// validateMessage checks msg against the validation rules of RFC 8415 section 16.
+// It returns the reason msg has to be discarded or an empty string if it is fine to answer it
+func validateMessage(msg *dhcpv6.Message, serverID *dhcpv6.Duid) string {
+	cid := msg.Options.ClientID()
+	sid := msg.Options.ServerID()
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRebind:
+		// RFC 8415 16.2, 16.5, 16.7: sent to all servers, must not be addressed to a specific one
+		if cid == nil {
+			return dropNoClientID
+		}
+		if sid != nil {
+			return dropServerIDPresent
+		}
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeDecline, dhcpv6.MessageTypeRelease:
+		// RFC 8415 16.4, 16.6, 16.8, 16.9: only answer if addressed to us
+		if cid == nil {
+			return dropNoClientID
+		}
+		if sid == nil {
+			return dropNoServerID
+		}
+		if !sid.Equal(*serverID) {
+			return dropServerIDMismatch
+		}
+	case dhcpv6.MessageTypeInformationRequest:
+		// RFC 8415 16.12: client id is optional, a server id has to be ours and no IAs are allowed
+		if sid != nil && !sid.Equal(*serverID) {
+			return dropServerIDMismatch
+		}
+		if msg.Options.GetOne(dhcpv6.OptionIANA) != nil || msg.Options.GetOne(dhcpv6.OptionIATA) != nil ||
+			msg.Options.GetOne(dhcpv6.OptionIAPD) != nil {
+			return dropIAPresent
+		}
+	case dhcpv6.MessageTypeAdvertise, dhcpv6.MessageTypeReply, dhcpv6.MessageTypeReconfigure,
+		dhcpv6.MessageTypeRelayReply:
+		// RFC 8415 16.3, 16.10, 16.11: server messages are never meant for us
+		return dropClientMessageType
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestValidateMessage(t *testing.T) {
+	ours := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}}
+	other := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}}
+	client := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}}
+
+	tests := []struct {
+		name     string
+		typ      dhcpv6.MessageType
+		clientID *dhcpv6.Duid
+		serverID *dhcpv6.Duid
+		ia       dhcpv6.Option
+		want     string
+	}{
+		// RFC 8415 16.2, 16.5, 16.7: Solicit, Confirm and Rebind go to all servers
+		{"solicit", dhcpv6.MessageTypeSolicit, client, nil, nil, ""},
+		{"solicit without client id", dhcpv6.MessageTypeSolicit, nil, nil, nil, dropNoClientID},
+		{"solicit with server id", dhcpv6.MessageTypeSolicit, client, ours, nil, dropServerIDPresent},
+		{"confirm", dhcpv6.MessageTypeConfirm, client, nil, nil, ""},
+		{"confirm without client id", dhcpv6.MessageTypeConfirm, nil, nil, nil, dropNoClientID},
+		{"confirm with server id", dhcpv6.MessageTypeConfirm, client, ours, nil, dropServerIDPresent},
+		{"rebind", dhcpv6.MessageTypeRebind, client, nil, nil, ""},
+		{"rebind without client id", dhcpv6.MessageTypeRebind, nil, nil, nil, dropNoClientID},
+		{"rebind with server id", dhcpv6.MessageTypeRebind, client, ours, nil, dropServerIDPresent},
+
+		// RFC 8415 16.4, 16.6, 16.8, 16.9: Request, Renew, Decline and Release are addressed to one server
+		{"request", dhcpv6.MessageTypeRequest, client, ours, nil, ""},
+		{"request without client id", dhcpv6.MessageTypeRequest, nil, ours, nil, dropNoClientID},
+		{"request without server id", dhcpv6.MessageTypeRequest, client, nil, nil, dropNoServerID},
+		{"request to other server", dhcpv6.MessageTypeRequest, client, other, nil, dropServerIDMismatch},
+		{"renew", dhcpv6.MessageTypeRenew, client, ours, nil, ""},
+		{"renew without client id", dhcpv6.MessageTypeRenew, nil, ours, nil, dropNoClientID},
+		{"renew without server id", dhcpv6.MessageTypeRenew, client, nil, nil, dropNoServerID},
+		{"renew to other server", dhcpv6.MessageTypeRenew, client, other, nil, dropServerIDMismatch},
+		{"decline", dhcpv6.MessageTypeDecline, client, ours, nil, ""},
+		{"decline without server id", dhcpv6.MessageTypeDecline, client, nil, nil, dropNoServerID},
+		{"decline to other server", dhcpv6.MessageTypeDecline, client, other, nil, dropServerIDMismatch},
+		{"release", dhcpv6.MessageTypeRelease, client, ours, nil, ""},
+		{"release without client id", dhcpv6.MessageTypeRelease, nil, ours, nil, dropNoClientID},
+		{"release to other server", dhcpv6.MessageTypeRelease, client, other, nil, dropServerIDMismatch},
+
+		// RFC 8415 16.12: Information-Request may go without client id, never with IAs
+		{"information-request", dhcpv6.MessageTypeInformationRequest, client, nil, nil, ""},
+		{"information-request without client id", dhcpv6.MessageTypeInformationRequest, nil, nil, nil, ""},
+		{"information-request with our server id", dhcpv6.MessageTypeInformationRequest, client, ours, nil, ""},
+		{"information-request to other server", dhcpv6.MessageTypeInformationRequest, client, other, nil, dropServerIDMismatch},
+		{"information-request with ia_na", dhcpv6.MessageTypeInformationRequest, client, nil, &dhcpv6.OptIANA{}, dropIAPresent},
+		{"information-request with ia_ta", dhcpv6.MessageTypeInformationRequest, client, nil, &dhcpv6.OptIATA{}, dropIAPresent},
+		{"information-request with ia_pd", dhcpv6.MessageTypeInformationRequest, client, nil, &dhcpv6.OptIAPD{}, dropIAPresent},
+
+		// RFC 8415 16.3, 16.10, 16.11: server messages
+		{"advertise", dhcpv6.MessageTypeAdvertise, client, ours, nil, dropClientMessageType},
+		{"reply", dhcpv6.MessageTypeReply, client, ours, nil, dropClientMessageType},
+		{"reconfigure", dhcpv6.MessageTypeReconfigure, client, ours, nil, dropClientMessageType},
+		{"relay-reply", dhcpv6.MessageTypeRelayReply, client, ours, nil, dropClientMessageType},
+	}
+	for _, tt := range tests {
+		msg, err := dhcpv6.NewMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.MessageType = tt.typ
+		if tt.clientID != nil {
+			msg.AddOption(dhcpv6.OptClientID(*tt.clientID))
+		}
+		if tt.serverID != nil {
+			msg.AddOption(dhcpv6.OptServerID(*tt.serverID))
+		}
+		if tt.ia != nil {
+			msg.AddOption(tt.ia)
+		}
+		if got := validateMessage(msg, ours); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// VendorConfig is the Vendor Class and Vendor-specific Information handed out for an enterprise number
+type VendorConfig struct {
+	Enterprise uint32            `json:"enterprise"`
+	Class      []string          `json:"class,omitempty"`
+	Options    []VendorSuboption `json:"options,omitempty"`
+	Match      VendorMatch       `json:"match"`
+}
+
+// VendorSuboption is an option within the Vendor-specific Information option, encoded like custom options
+type VendorSuboption struct {
+	Code  uint16      `json:"code"`
+	Type  string      `json:"type"`
+	Value OptionValue `json:"value"`
+
+	data []byte
+}
+
+// VendorMatch selects the clients a vendor entry is sent to, every criteria set has to match.
+// an empty match applies to all clients
+type VendorMatch struct {
+	Arch        []uint16 `json:"arch,omitempty"`
+	VendorClass string   `json:"vendor_class,omitempty"`
+	UserClass   string   `json:"user_class,omitempty"`
+
+	vendorClass *regexp.Regexp
+	userClass   *regexp.Regexp
+}
+
+// defaultVendors hands the HTTPClient vendor class to HTTP boot clients, they identify with an
+// "HTTPClient:Arch:..." vendor class of their own whatever architecture they are
+var defaultVendors = []VendorConfig{
+	{
+		Enterprise: 10,
+		Class:      []string{"HTTPClient"},
+		Match: VendorMatch{
+			VendorClass: "^HTTPClient",
+			vendorClass: regexp.MustCompile("^HTTPClient"),
+		},
+	},
+}
+
+// build validates the entry, compiles the match and encodes the suboptions
+func (v *VendorConfig) build() error {
+	if v.Enterprise == 0 {
+		return fmt.Errorf("vendor without enterprise number")
+	}
+	if len(v.Class) == 0 && len(v.Options) == 0 {
+		return fmt.Errorf("vendor %d has neither class nor options", v.Enterprise)
+	}
+	for i := range v.Options {
+		o := &v.Options[i]
+		if o.Code == 0 {
+			return fmt.Errorf("vendor %d: option code 0 can't be set", v.Enterprise)
+		}
+		data, err := encodeOption(o.Code, o.Type, o.Value)
+		if err != nil {
+			return fmt.Errorf("vendor %d: %w", v.Enterprise, err)
+		}
+		o.data = data
+	}
+	var err error
+	if v.Match.VendorClass != "" {
+		if v.Match.vendorClass, err = regexp.Compile(v.Match.VendorClass); err != nil {
+			return fmt.Errorf("vendor %d: invalid vendor_class match: %w", v.Enterprise, err)
+		}
+	}
+	if v.Match.UserClass != "" {
+		if v.Match.userClass, err = regexp.Compile(v.Match.UserClass); err != nil {
+			return fmt.Errorf("vendor %d: invalid user_class match: %w", v.Enterprise, err)
+		}
+	}
+	return nil
+}
+
+// matches checks if msg was sent by a client the entry is meant for
+func (m VendorMatch) matches(msg *dhcpv6.Message) bool {
+	if len(m.Arch) > 0 {
+		found := false
+		for _, a := range m.Arch {
+			if msg.Options.ArchTypes().Contains(iana.Arch(a)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.vendorClass != nil {
+		found := false
+		for _, o := range msg.Options.Get(dhcpv6.OptionVendorClass) {
+			vc, ok := o.(*dhcpv6.OptVendorClass)
+			if !ok {
+				continue
+			}
+			for _, d := range vc.Data {
+				if m.vendorClass.Match(d) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.userClass != nil && !m.userClass.MatchString(strings.Join(userClasses(msg), " ")) {
+		return false
+	}
+	return true
+}
+
+// userClasses returns the user classes sent by the client
+func userClasses(msg *dhcpv6.Message) []string {
+	var classes []string
+	for _, o := range msg.Options.Get(dhcpv6.OptionUserClass) {
+		if uc, ok := o.(*dhcpv6.OptUserClass); ok {
+			for _, c := range uc.UserClasses {
+				classes = append(classes, string(c))
+			}
+		}
+	}
+	return classes
+}
+
+// vendorOptions returns the Vendor Class and Vendor-specific Information options requested by msg.
+// of several entries for an enterprise number only the first matching one is used
+func vendorOptions(msg *dhcpv6.Message, vendors []VendorConfig) []dhcpv6.Option {
+	var opts []dhcpv6.Option
+	seen := map[uint32]bool{}
+	for _, v := range vendors {
+		if seen[v.Enterprise] || !v.Match.matches(msg) {
+			continue
+		}
+		seen[v.Enterprise] = true
+		if len(v.Class) > 0 && msg.IsOptionRequested(dhcpv6.OptionVendorClass) {
+			o := &dhcpv6.OptVendorClass{EnterpriseNumber: v.Enterprise}
+			for _, c := range v.Class {
+				o.Data = append(o.Data, []byte(c))
+			}
+			opts = append(opts, o)
+		}
+		if len(v.Options) > 0 && msg.IsOptionRequested(dhcpv6.OptionVendorOpts) {
+			o := &dhcpv6.OptVendorOpts{EnterpriseNumber: v.Enterprise}
+			for _, so := range v.Options {
+				o.VendorOpts.Add(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(so.Code), OptionData: so.data})
+			}
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}